@@ -4,10 +4,17 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -16,16 +23,7 @@ import (
 )
 
 const (
-	MINING_DIFFICULTY = 3
-	MINING_SENDER = "THE BLOCKCHAIN"
-	MINING_REWARD = 1.000000000000000000
-	MINING_TIMER_SEC = 20
-
-	BLOCKCHAIN_PORT_RANGE_START = 5000
-	BLOCKCHAIN_PORT_RANGE_END = 5003
-	NEIGHBOUR_IP_RANGE_START = 0
-	NEIGHBOUR_IP_RANGE_END = 1
-	BLOCKCHAIN_NEIGHBOUR_SYNC_TIME_SEC = 20
+	NEIGHBOUR_REQUEST_TIMEOUT_SEC = 5
 )
 
 type Block struct {
@@ -33,10 +31,13 @@ type Block struct {
 	nonce			int
 	previousHash	[32]byte
 	transactions	[]*Transaction
+	extraData		[]byte
+	height			int
 }
 
-func NewBlock(nonce int, previousHash [32]byte, transactions	[]*Transaction) *Block {
+func NewBlock(height int, nonce int, previousHash [32]byte, transactions	[]*Transaction) *Block {
 	b := new(Block)
+	b.height = height
 	b.timestamp = time.Now().UnixNano()
 	b.nonce = nonce
 	b.previousHash = previousHash
@@ -45,6 +46,7 @@ func NewBlock(nonce int, previousHash [32]byte, transactions	[]*Transaction) *Bl
 }
 
 func (b *Block) Print() {
+	fmt.Printf("Height			%d\n", b.height)
 	fmt.Printf("Timestamp		%d\n", b.timestamp)
 	fmt.Printf("Nonce			%d\n", b.nonce)
 	fmt.Printf("Previous_Hash		%x\n", b.previousHash)
@@ -54,102 +56,665 @@ func (b *Block) Print() {
 }
 
 func (b *Block) Hash() [32]byte { 
-	m, _ := json.Marshal(b)
-	return sha256.Sum256([]byte(m))
+	return b.Header().Hash()
+}
+
+func (b *Block) Header() *BlockHeader {
+	return &BlockHeader{b.timestamp, b.nonce, b.previousHash, TransactionsHash(b.transactions), b.extraData, b.height}
+}
+
+func (b *Block) Height() int {
+	return b.height
+}
+
+func (b *Block) PreviousHash() [32]byte {
+	return b.previousHash
+}
+
+func (b *Block) Transactions() []*Transaction {
+	return b.transactions
 }
 
 func (b *Block) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct{
+		Height			int				`json:"height"`
+		Hash			string			`json:"hash"`
 		Timestamp		int64			`json:"timestamp"`
 		Nonce			int				`json:"nonce"`
 		PreviousHash	string		`json:"previous_hash"`
 		Transactions	[]*Transaction	`json:"transactions"`
+		ExtraData		string			`json:"extra_data,omitempty"`
 	}{
+		Height: b.height,
+		Hash: hashString(b.Hash()),
 		Timestamp: b.timestamp,
 		Nonce: b.nonce,
 		PreviousHash: fmt.Sprintf("%x", b.previousHash),
 		Transactions: b.transactions,
+		ExtraData: hex.EncodeToString(b.extraData),
+	})
+}
+
+func (b *Block) UnmarshalJSON(data []byte) error {
+	var v struct{
+		Height			*int			`json:"height"`
+		Timestamp		*int64			`json:"timestamp"`
+		Nonce			*int			`json:"nonce"`
+		PreviousHash	*string			`json:"previous_hash"`
+		Transactions	[]*Transaction	`json:"transactions"`
+		ExtraData		string			`json:"extra_data"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Height == nil || v.Timestamp == nil || v.Nonce == nil || v.PreviousHash == nil {
+		return fmt.Errorf("block is missing field(s)")
+	}
+	previousHash, err := hashFromString(*v.PreviousHash)
+	if err != nil {
+		return err
+	}
+	extraData, err := extraDataFromString(v.ExtraData)
+	if err != nil {
+		return err
+	}
+	b.height = *v.Height
+	b.timestamp = *v.Timestamp
+	b.nonce = *v.Nonce
+	b.previousHash = previousHash
+	b.transactions = v.Transactions
+	b.extraData = extraData
+	if b.transactions == nil {
+		b.transactions = []*Transaction{}
+	}
+	return nil
+}
+
+// BlockHeader commits to a block's transactions through their hash, so a
+// chain of headers can be checked for linkage and proof of work before any
+// block bodies are downloaded.
+type BlockHeader struct {
+	timestamp			int64
+	nonce				int
+	previousHash		[32]byte
+	transactionsHash	[32]byte
+	extraData			[]byte
+	height				int
+}
+
+func (h *BlockHeader) Hash() [32]byte {
+	m, _ := json.Marshal(h)
+	return sha256.Sum256([]byte(m))
+}
+
+func (h *BlockHeader) PreviousHash() [32]byte {
+	return h.previousHash
+}
+
+func (h *BlockHeader) Height() int {
+	return h.height
+}
+
+func (h *BlockHeader) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct{
+		Height				int		`json:"height"`
+		Timestamp			int64	`json:"timestamp"`
+		Nonce				int		`json:"nonce"`
+		PreviousHash		string	`json:"previous_hash"`
+		TransactionsHash	string	`json:"transactions_hash"`
+		ExtraData			string	`json:"extra_data,omitempty"`
+	}{
+		Height: h.height,
+		Timestamp: h.timestamp,
+		Nonce: h.nonce,
+		PreviousHash: fmt.Sprintf("%x", h.previousHash),
+		TransactionsHash: fmt.Sprintf("%x", h.transactionsHash),
+		ExtraData: hex.EncodeToString(h.extraData),
 	})
 }
 
+func (h *BlockHeader) UnmarshalJSON(data []byte) error {
+	var v struct{
+		Height				*int	`json:"height"`
+		Timestamp			*int64	`json:"timestamp"`
+		Nonce				*int	`json:"nonce"`
+		PreviousHash		*string	`json:"previous_hash"`
+		TransactionsHash	*string	`json:"transactions_hash"`
+		ExtraData			string	`json:"extra_data"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Height == nil || v.Timestamp == nil || v.Nonce == nil || v.PreviousHash == nil || v.TransactionsHash == nil {
+		return fmt.Errorf("block header is missing field(s)")
+	}
+	previousHash, err := hashFromString(*v.PreviousHash)
+	if err != nil {
+		return err
+	}
+	transactionsHash, err := hashFromString(*v.TransactionsHash)
+	if err != nil {
+		return err
+	}
+	extraData, err := extraDataFromString(v.ExtraData)
+	if err != nil {
+		return err
+	}
+	h.height = *v.Height
+	h.timestamp = *v.Timestamp
+	h.nonce = *v.Nonce
+	h.previousHash = previousHash
+	h.transactionsHash = transactionsHash
+	h.extraData = extraData
+	return nil
+}
+
+func TransactionsHash(transactions []*Transaction) [32]byte {
+	if transactions == nil {
+		transactions = []*Transaction{}
+	}
+	m, _ := json.Marshal(transactions)
+	return sha256.Sum256([]byte(m))
+}
+
+func extraDataFromString(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return hex.DecodeString(s)
+}
+
+func hashString(h [32]byte) string {
+	return fmt.Sprintf("%x", h)
+}
+
+func hashFromString(s string) ([32]byte, error) {
+	var h [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return h, err
+	}
+	if len(b) != len(h) {
+		return h, fmt.Errorf("hash must be %d bytes, got %d", len(h), len(b))
+	}
+	copy(h[:], b)
+	return h, nil
+}
+
 type Blockchain struct {
-	transactionPool		[]*Transaction
+	mempool				*Mempool
 	chain				[]*Block
+	index				*AddressIndex
 	blockchainAddress	string
 	port				uint16
-	mux 				sync.Mutex
+	// Guards chain and index. Readers share it, only connecting, mining and
+	// replacing blocks take it exclusively.
+	mux 				sync.RWMutex
+	// Lets one proof of work run at a time, without holding the chain lock.
+	muxMining			sync.Mutex
+	miningTimer			*time.Timer
+	muxMiningTimer		sync.Mutex
+	// Hashes tried by every proof of work, and the rate of the last one.
+	hashes				uint64
+	hashRate			float64
+	muxHashRate			sync.Mutex
 
 	neighbours			[]string 
 	muxNeighbours		sync.Mutex
+	peers				*PeerManager
+	syncer				*Syncer
+	gossip				*Gossip
+	broadcaster			*Broadcaster
+	client				*http.Client
+	wire				*WireNode
+	scheme				string
+	serverTLS			*tls.Config
+	clientTLS			*tls.Config
+	params				*ChainParams
+	events				*EventBus
 }
 
-func NewBlockchain(blockchainAddress string, port uint16) *Blockchain {
-	b := &Block{}
+func NewBlockchain(blockchainAddress string, port uint16, peers *PeerManager, mempool *Mempool, params *ChainParams) *Blockchain {
 	bc := new(Blockchain)
 	bc.blockchainAddress = blockchainAddress
-	bc.CreateBlock(0, b.Hash())
+	bc.params = params
+	bc.chain = []*Block{params.Genesis()}
+	bc.index = NewAddressIndex()
+	bc.index.add(bc.chain[0])
+	bc.mempool = mempool
+	bc.events = NewEventBus()
+	bc.peers = peers
+	bc.syncer = NewSyncer(bc)
+	bc.gossip = NewGossip(bc)
+	bc.broadcaster = NewBroadcaster(bc, BROADCAST_WORKERS)
+	bc.client = &http.Client{Timeout: time.Second * NEIGHBOUR_REQUEST_TIMEOUT_SEC}
+	bc.scheme = "http"
 	bc.port = port
 	return bc
 }
 
+func (bc *Blockchain) Params() *ChainParams {
+	return bc.params
+}
+
+func (bc *Blockchain) Peers() *PeerManager {
+	return bc.peers
+}
+
+func (bc *Blockchain) Syncer() *Syncer {
+	return bc.syncer
+}
+
+func (bc *Blockchain) Gossip() *Gossip {
+	return bc.gossip
+}
+
+// UseTLS makes the node talk to neighbours over TLS, both for HTTP and the
+// wire protocol. It must be called before Run and EnableWire.
+func (bc *Blockchain) UseTLS(server *tls.Config, client *tls.Config) {
+	bc.serverTLS = server
+	bc.clientTLS = client
+	bc.scheme = "https"
+	bc.client = &http.Client{
+		Timeout: time.Second * NEIGHBOUR_REQUEST_TIMEOUT_SEC,
+		Transport: &http.Transport{TLSClientConfig: client},
+	}
+}
+
+// EnableWire accepts wire protocol connections on the port paired with the
+// HTTP port and dials neighbours over it whenever they are refreshed.
+func (bc *Blockchain) EnableWire() error {
+	address, err := WireAddress(net.JoinHostPort("0.0.0.0", strconv.Itoa(int(bc.port))))
+	if err != nil {
+		return err
+	}
+	wn := NewWireNode(bc)
+	if err := wn.Listen(address); err != nil {
+		return err
+	}
+	bc.wire = wn
+	return nil
+}
+
+// Address is the host:port neighbours reach this node on.
+func (bc *Blockchain) Address() string {
+	return net.JoinHostPort(utils.GetHost(), strconv.Itoa(int(bc.port)))
+}
+
 func (bc *Blockchain) Run() {
 	bc.StartSyncNeighbours()
 }
 
 func (bc *Blockchain) SetNeighbours() {
+	previous := make(map[string]bool, len(bc.neighbours))
+	for _, n := range bc.neighbours {
+		previous[n] = true
+	}
 	bc.neighbours = utils.FindNeighbours(
 		utils.GetHost(), bc.port,
-		NEIGHBOUR_IP_RANGE_START, NEIGHBOUR_IP_RANGE_END,
-		BLOCKCHAIN_PORT_RANGE_START, BLOCKCHAIN_PORT_RANGE_END)
+		bc.params.NeighbourIPRangeStart, bc.params.NeighbourIPRangeEnd,
+		bc.params.PortRangeStart, bc.params.PortRangeEnd)
+	bc.neighbours = bc.peers.Filter(bc.neighbours)
+	for _, n := range bc.neighbours {
+		bc.peers.Seen(n)
+		if previous[n] {
+			delete(previous, n)
+		} else {
+			bc.events.Publish(&Event{Type: EventPeerAdded, Peer: n})
+		}
+	}
+	// Banned peers are filtered out above, so this also stops sending
+	// to them.
+	for n := range previous {
+		bc.broadcaster.Remove(n)
+		bc.events.Publish(&Event{Type: EventPeerRemoved, Peer: n})
+	}
 	log.Printf("%v", bc.neighbours)
 }
 
-func (bc *Blockchain) SyncNeighbours() {
+func (bc *Blockchain) Neighbours() []string {
 	bc.muxNeighbours.Lock()
 	defer bc.muxNeighbours.Unlock()
+	neighbours := make([]string, len(bc.neighbours))
+	copy(neighbours, bc.neighbours)
+	return neighbours
+}
+
+// IsNeighbourAt reports whether address is one of our neighbours and on
+// host. Peers name their own address, so it is only believed when the
+// connection comes from that host; otherwise anyone could speak for a
+// neighbour, or have us contact an address of their choosing.
+func (bc *Blockchain) IsNeighbourAt(address string, host string) bool {
+	h, _, err := net.SplitHostPort(address)
+	if err != nil || h != host {
+		return false
+	}
+	for _, n := range bc.Neighbours() {
+		if n == address {
+			return true
+		}
+	}
+	return false
+}
+
+// NeighbourStatusError is returned when a neighbour answers with a status
+// other than 200 OK. RetryAfter is how long the neighbour asked us to wait
+// before trying again, if it did.
+type NeighbourStatusError struct {
+	Endpoint	string
+	StatusCode	int
+	RetryAfter	time.Duration
+}
+
+func (e *NeighbourStatusError) Error() string {
+	return fmt.Sprintf("%s returned %d %s", e.Endpoint, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// retryAfter reads a Retry-After header, either in seconds or as a date.
+func retryAfter(v string) time.Duration {
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Second * time.Duration(seconds)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// getFromNeighbour GETs path from a neighbour and decodes the JSON response
+// into v, scoring the neighbour on timeouts and malformed responses.
+func (bc *Blockchain) getFromNeighbour(neighbour string, path string, v interface{}) error {
+	return bc.requestNeighbour(http.MethodGet, neighbour, path, nil, v)
+}
+
+func (bc *Blockchain) postToNeighbour(neighbour string, path string, body []byte, v interface{}) error {
+	return bc.requestNeighbour(http.MethodPost, neighbour, path, body, v)
+}
+
+func (bc *Blockchain) requestNeighbour(method string, neighbour string, path string, body []byte, v interface{}) error {
+	endpoint := fmt.Sprintf("%s://%s%s", bc.scheme, neighbour, path)
+	req, err := http.NewRequest(method, endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(NODE_ADDRESS_HEADER, bc.Address())
+	req.Header.Set(NODE_CHAIN_ID_HEADER, strconv.Itoa(int(bc.params.ChainID)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := bc.client.Do(req)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			bc.peers.Misbehaving(neighbour, MisbehaviourTimeout)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if !bc.peers.BindIdentity(neighbour, resp.TLS) {
+		return fmt.Errorf("%s presented another node's certificate", neighbour)
+	}
+	bc.peers.Seen(neighbour)
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return &NeighbourStatusError{endpoint, resp.StatusCode, retryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if v == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		bc.peers.Misbehaving(neighbour, MisbehaviourMalformedJSON)
+		return err
+	}
+	return nil
+}
+
+func (bc *Blockchain) SyncNeighbours() {
+	bc.muxNeighbours.Lock()
 	bc.SetNeighbours()
+	bc.muxNeighbours.Unlock()
+	if bc.wire != nil {
+		bc.wire.ConnectNeighbours()
+	}
+	// Poll the height of every neighbour. Over TLS this also binds the
+	// identity of new neighbours before they make requests of their own.
+	for _, n := range bc.Neighbours() {
+		go bc.syncer.fetchHeaders(n, 0, 0)
+	}
 }
 
 func (bc *Blockchain) StartSyncNeighbours() {
 	bc.SyncNeighbours()
-	_ = time.AfterFunc(time.Second * BLOCKCHAIN_NEIGHBOUR_SYNC_TIME_SEC, bc.StartSyncNeighbours)
+	_ = time.AfterFunc(time.Second * time.Duration(bc.params.NeighbourSyncTimeSec), bc.StartSyncNeighbours)
 }
 
 func (bc *Blockchain) TransactionPool() []*Transaction {
-	return bc.transactionPool
+	return bc.mempool.Transactions()
 }
 
 func (bc *Blockchain)  ClearTransactionPool() {
-	bc.transactionPool = bc.transactionPool[:0]
+	bc.mempool.Clear()
+}
+
+func (bc *Blockchain) Mempool() *Mempool {
+	return bc.mempool
+}
+
+func (bc *Blockchain) Events() *EventBus {
+	return bc.events
 }
 
 func (bc *Blockchain) MarshalJSON() ([]byte, error) {
+	bc.mux.RLock()
+	chain := bc.chain
+	bc.mux.RUnlock()
+
 	return json.Marshal(struct{
 		Blocks []*Block	`json:"chains"`
 	}{
-		Blocks: bc.chain,
+		Blocks: chain,
 	})
 }
 
-func (bc *Blockchain) CreateBlock(nonce int, previousHash [32]byte) *Block {
-	b := NewBlock(nonce, previousHash, bc.transactionPool)
-	bc.chain = append(bc.chain, b)
-	bc.transactionPool = []*Transaction{}
-	for _, n := range bc.neighbours {
-		endpoint := fmt.Sprintf("http://%s/transactions", n)
-			client := &http.Client{}
-			req, _ := http.NewRequest("DELETE", endpoint, nil)
-			resp, _ :=  client.Do(req)
-			log.Printf("%v", resp)
+// CreateBlock appends a block built on previousHash. It returns nil if the
+// tip has moved on since the proof of work started.
+func (bc *Blockchain) CreateBlock(nonce int, previousHash [32]byte, transactions []*Transaction) *Block {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	if previousHash != bc.lastBlock().Hash() {
+		return nil
 	}
+	b := NewBlock(len(bc.chain), nonce, previousHash, transactions)
+	bc.chain = append(bc.chain, b)
+	bc.index.add(b)
+	bc.removeConfirmed([]*Block{b})
+	bc.events.Publish(&Event{Type: EventBlockConnected, Block: b})
 	return b
 }
 
 func (bc *Blockchain) LastBlock() *Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.lastBlock()
+}
+
+func (bc *Blockchain) lastBlock() *Block {
 	return bc.chain[len(bc.chain) - 1]
 }
 
+// Height is the number of blocks on top of the genesis block.
+func (bc *Blockchain) Height() int {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return len(bc.chain) - 1
+}
+
+// Headers returns up to limit headers starting at height from.
+func (bc *Blockchain) Headers(from int, limit int) []*BlockHeader {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
+	headers := make([]*BlockHeader, 0)
+	for i := from; i >= 0 && i < len(bc.chain) && len(headers) < limit; i++ {
+		headers = append(headers, bc.chain[i].Header())
+	}
+	return headers
+}
+
+// Blocks returns the blocks from height from to height to, inclusive.
+func (bc *Blockchain) Blocks(from int, to int) []*Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
+	blocks := make([]*Block, 0)
+	for i := from; i >= 0 && i <= to && i < len(bc.chain); i++ {
+		blocks = append(blocks, bc.chain[i])
+	}
+	return blocks
+}
+
+// BlockAt returns the block at height, or nil if the chain is shorter.
+func (bc *Blockchain) BlockAt(height int) *Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
+	if height < 0 || height >= len(bc.chain) {
+		return nil
+	}
+	return bc.chain[height]
+}
+
+// Tip returns the last block of the chain.
+func (bc *Blockchain) Tip() *Block {
+	return bc.LastBlock()
+}
+
+func (bc *Blockchain) BlockByHash(hash [32]byte) *Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
+	for i := len(bc.chain) - 1; i >= 0; i-- {
+		if bc.chain[i].Hash() == hash {
+			return bc.chain[i]
+		}
+	}
+	return nil
+}
+
+func (bc *Blockchain) PendingTransaction(hash [32]byte) *Transaction {
+	return bc.mempool.Get(hash)
+}
+
+var (
+	ErrUnknownParent = errors.New("block does not build on the chain tip")
+	ErrBadProofOfWork = errors.New("block has an invalid proof of work")
+	ErrChainNotLonger = errors.New("chain is not longer than the local one")
+)
+
+// ConnectBlock appends a block received from a neighbour to the tip of the
+// chain and drops the transactions it confirms from the pool. The block's
+// transactions must be valid against the chain it builds on.
+func (bc *Blockchain) ConnectBlock(b *Block) error {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	if b.previousHash != bc.lastBlock().Hash() || b.height != len(bc.chain) {
+		return ErrUnknownParent
+	}
+	if !ValidHeaderProof(b.Header(), bc.params.MiningDifficulty) {
+		return ErrBadProofOfWork
+	}
+	if err := newLedger(bc.chain).check(b, bc.params); err != nil {
+		return err
+	}
+	bc.chain = append(bc.chain, b)
+	bc.index.add(b)
+	bc.removeConfirmed([]*Block{b})
+	bc.events.Publish(&Event{Type: EventBlockConnected, Block: b})
+	log.Printf("action=connect_block, height=%d", len(bc.chain)-1)
+	return nil
+}
+
+// removeConfirmed drops the pool transactions included in blocks, and any
+// other pending transaction of their senders that reuses a confirmed nonce.
+// It is called with the chain lock held.
+func (bc *Blockchain) removeConfirmed(blocks []*Block) {
+	for _, b := range blocks {
+		nextNonces := make(map[string]uint64)
+		for _, t := range b.transactions {
+			if t.senderAddress != bc.params.MiningSender {
+				nextNonces[t.senderAddress] = bc.confirmedNonce(t.senderAddress)
+			}
+		}
+		bc.mempool.RemoveConfirmed(b.transactions, nextNonces)
+	}
+}
+
+// ValidHeaders checks that every header links to the one before it and
+// carries a valid proof of work. The first header is trusted as the anchor.
+func ValidHeaders(headers []*BlockHeader, difficulty int) bool {
+	for i := 1; i < len(headers); i++ {
+		if headers[i].previousHash != headers[i-1].Hash() || headers[i].height != headers[i-1].height+1 {
+			return false
+		}
+		if !ValidHeaderProof(headers[i], difficulty) {
+			return false
+		}
+	}
+	return true
+}
+
+// ReplaceChain swaps in blocks from height from onwards, keeping the local
+// blocks below it, the genesis block at least. The resulting chain must be
+// longer than the current one, and every new block must build on the one
+// before it with a valid proof of work and valid transactions.
+func (bc *Blockchain) ReplaceChain(from int, blocks []*Block) error {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	if from < 1 || from > len(bc.chain) {
+		return ErrUnknownParent
+	}
+	if from+len(blocks) <= len(bc.chain) {
+		return ErrChainNotLonger
+	}
+	l := newLedger(bc.chain[:from])
+	parent := bc.chain[from-1]
+	for _, b := range blocks {
+		if b.previousHash != parent.Hash() || b.height != parent.height+1 {
+			return ErrUnknownParent
+		}
+		if !ValidHeaderProof(b.Header(), bc.params.MiningDifficulty) {
+			return ErrBadProofOfWork
+		}
+		if err := l.check(b, bc.params); err != nil {
+			return err
+		}
+		l.apply(b)
+		parent = b
+	}
+	for i := len(bc.chain) - 1; i >= from; i-- {
+		bc.events.Publish(&Event{Type: EventBlockDisconnected, Block: bc.chain[i]})
+	}
+	chain := make([]*Block, 0, from+len(blocks))
+	chain = append(chain, bc.chain[:from]...)
+	chain = append(chain, blocks...)
+	bc.chain = chain
+	bc.index.truncate(from)
+	for _, b := range blocks {
+		bc.index.add(b)
+	}
+	bc.removeConfirmed(blocks)
+	for _, b := range blocks {
+		bc.events.Publish(&Event{Type: EventBlockConnected, Block: b})
+	}
+	log.Printf("action=replace_chain, from=%d, height=%d", from, len(bc.chain)-1)
+	return nil
+}
+
 func (bc *Blockchain) Print() {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
 	for i, block := range bc.chain {
 		fmt.Printf("%s Block %d %s\n", strings.Repeat("=", 25), i, strings.Repeat("=", 25))
 		block.Print()
@@ -161,109 +726,293 @@ type Transaction struct {
 	senderAddress		string
 	receiverAddress		string
 	value 				float64
+	fee					float64
+	// Orders the sender's transactions. A pending transaction can be
+	// replaced by another with the same nonce and a higher fee.
+	nonce				uint64
+
+	// Kept for pending transactions so they can be relayed to neighbours.
+	senderPublicKey		*ecdsa.PublicKey
+	signature			*utils.Signature
+}
+
+// Hash identifies a transaction by the JSON of its sender, receiver, value,
+// fee and nonce. It is not what the signature covers, see SigningHash.
+func (t *Transaction) Hash() [32]byte {
+	m, _ := json.Marshal(t)
+	return sha256.Sum256([]byte(m))
+}
+
+// SigningHash is the digest the sender signs, see
+// utils.TransactionSigningBytes.
+func (t *Transaction) SigningHash(chainID uint32) [32]byte {
+	return utils.TransactionSigningHash(chainID, t.senderAddress, t.receiverAddress, t.value, t.fee, t.nonce)
+}
+
+func (t *Transaction) SenderAddress() string {
+	return t.senderAddress
+}
+
+func (t *Transaction) ReceiverAddress() string {
+	return t.receiverAddress
+}
+
+// Request rebuilds the signed request for a pending transaction, or returns
+// nil if the transaction carries no signature.
+func (t *Transaction) Request() *TransactionRequest {
+	if t.senderPublicKey == nil || t.signature == nil {
+		return nil
+	}
+	sender, receiver, value, fee, nonce := t.senderAddress, t.receiverAddress, t.value, t.fee, t.nonce
+	publicKeyStr := fmt.Sprintf("%064x%064x", t.senderPublicKey.X.Bytes(), t.senderPublicKey.Y.Bytes())
+	signatureStr := t.signature.String()
+	return &TransactionRequest{&sender, &receiver, &publicKeyStr, &value, &fee, &nonce, &signatureStr}
 }
 
 func (bc *Blockchain) CreateTransaction(
-	sender string, receiver string, value float64, senderPublicKey *ecdsa.PublicKey, s *utils.Signature,
-) bool {
-	isTransacted := bc.AddTransaction(sender, receiver, value, senderPublicKey, s)
-
-	if isTransacted {
-		for _, n := range bc.neighbours {
-			publicKeyStr := fmt.Sprintf("%064x%064x", senderPublicKey.X.Bytes(), senderPublicKey.Y.Bytes())
-			signatureStr := s.String()
-			bt := &TransactionRequest{&sender, &receiver, &publicKeyStr, &value, &signatureStr}
-			m, _ := json.Marshal(bt)
-			buf := bytes.NewBuffer(m)
-			endpoint := fmt.Sprintf("http://%s/transactions", n)
-			client := &http.Client{}
-			req, _ := http.NewRequest("PUT", endpoint, buf)
-			resp, _ :=  client.Do(req)
-			log.Printf("%v", resp)
-		}
+	sender string, receiver string, value float64, fee float64, nonce uint64, senderPublicKey *ecdsa.PublicKey, s *utils.Signature,
+) error {
+	err := bc.AddTransaction(sender, receiver, value, fee, nonce, senderPublicKey, s)
+
+	if err == nil {
+		t := NewTransaction(sender, receiver, value, fee, nonce)
+		bc.gossip.Announce([]InvItem{{InvTypeTransaction, hashString(t.Hash())}}, "")
 	}
 
-	return isTransacted
+	return err
 }
 
-func (bc *Blockchain) AddTransaction(
-	sender string, receiver string, value float64, senderPublicKey *ecdsa.PublicKey, s *utils.Signature,
-) bool {
-	t := NewTransaction(sender, receiver, value)
+var (
+	ErrInvalidSignature = errors.New("transaction signature is invalid")
+	ErrInvalidValue = errors.New("transaction value must be positive and finite")
+	ErrInvalidFee = errors.New("transaction fee must be finite and not negative")
+	ErrMiningSender = errors.New("only miners may send from the mining sender")
+	ErrNonceTooLow = errors.New("transaction nonce is already confirmed")
+	ErrInsufficientFunds = errors.New("sender balance does not cover the value, fee and pending transactions")
+)
 
-	if sender == MINING_SENDER {
-		bc.transactionPool = append(bc.transactionPool,  t)
-		return true
+// IsInvalidTransaction reports whether err means the transaction can never
+// be accepted, as opposed to our mempool having no room for it.
+func IsInvalidTransaction(err error) bool {
+	return err == ErrInvalidSignature || err == ErrInvalidValue || err == ErrInvalidFee || err == ErrMiningSender
+}
+
+// checkAmounts refuses a value that would move coins out of the receiver,
+// a fee that would move them out of the miner, and amounts no balance can
+// be compared with.
+func checkAmounts(value float64, fee float64) error {
+	if value <= 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return ErrInvalidValue
 	}
+	if fee < 0 || math.IsNaN(fee) || math.IsInf(fee, 0) {
+		return ErrInvalidFee
+	}
+	return nil
+}
 
-	if bc.VerifyTransactionSignature(senderPublicKey, s, t){
-		// if bc.CalculateTotalAmount(sender) < value {
-		// 	log.Println("ERROR: Not enough balance in wallet")
-		// 	return false
-		// }
-		bc.transactionPool = append(bc.transactionPool, t)
-		return true
+// AddTransaction verifies a signed transaction and admits it to the mempool,
+// replacing a pending one with the same sender and nonce if it pays enough
+// more. Mining rewards are never pending, the miner adds them to its own
+// block.
+func (bc *Blockchain) AddTransaction(
+	sender string, receiver string, value float64, fee float64, nonce uint64, senderPublicKey *ecdsa.PublicKey, s *utils.Signature,
+) error {
+	err := bc.addTransaction(sender, receiver, value, fee, nonce, senderPublicKey, s)
+	t := NewTransaction(sender, receiver, value, fee, nonce)
+	if err != nil {
+		bc.events.Publish(&Event{Type: EventTransactionRejected, Transaction: t, Err: err})
 	} else {
+		bc.events.Publish(&Event{Type: EventNewTransaction, Transaction: t})
+	}
+	return err
+}
+
+func (bc *Blockchain) addTransaction(
+	sender string, receiver string, value float64, fee float64, nonce uint64, senderPublicKey *ecdsa.PublicKey, s *utils.Signature,
+) error {
+	t := NewTransaction(sender, receiver, value, fee, nonce)
+
+	if sender == bc.params.MiningSender {
+		return ErrMiningSender
+	}
+	if err := checkAmounts(value, fee); err != nil {
+		return err
+	}
+	if senderPublicKey == nil || s == nil || !bc.VerifyTransactionSignature(senderPublicKey, s, t) {
 		log.Println("ERROR: Verify Transaction")
+		return ErrInvalidSignature
 	}
-	return false
+	t.senderPublicKey = senderPublicKey
+	t.signature = s
+
+	// Hold the chain still while the mempool checks the balance and admits
+	// t, so that no block lands in between.
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	if nonce < bc.confirmedNonce(sender) {
+		return ErrNonceTooLow
+	}
+	return bc.mempool.Add(t, bc.calculateTotalAmount(sender))
 }
 
 func (bc *Blockchain) VerifyTransactionSignature(
 	senderPublicKey *ecdsa.PublicKey, s *utils.Signature, t *Transaction,
 ) bool {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
+	h := t.SigningHash(bc.params.ChainID)
 	return ecdsa.Verify(senderPublicKey, h[:], s.R, s.S)
 }
 
+// CopyTransactionPool copies the pending transactions to mine, leaving out
+// those with invalid amounts, those whose nonce a block has confirmed since
+// they were admitted and those their sender's balance no longer covers.
 func (bc *Blockchain) CopyTransactionPool() []*Transaction {
 	transactions := make([]*Transaction, 0)
-	for _, t := range bc.transactionPool{
-		transactions = append(transactions, NewTransaction(t.senderAddress, t.receiverAddress, t.value))
+	nextNonces := make(map[string]uint64)
+	balances := make(map[string]float64)
+	for _, t := range bc.mempool.Transactions() {
+		next, ok := nextNonces[t.senderAddress]
+		if !ok {
+			next = bc.ConfirmedNonce(t.senderAddress)
+			nextNonces[t.senderAddress] = next
+			balances[t.senderAddress] = bc.CalculateTotalAmount(t.senderAddress)
+		}
+		if checkAmounts(t.value, t.fee) != nil || t.nonce < next || balances[t.senderAddress] < t.value + t.fee {
+			continue
+		}
+		balances[t.senderAddress] -= t.value + t.fee
+		transactions = append(transactions, NewTransaction(t.senderAddress, t.receiverAddress, t.value, t.fee, t.nonce))
 	}
 	return transactions
 }
 
-func (bc *Blockchain) ValidProof(nonce int, previousHash [32]byte, transactions []*Transaction, difficulty int) bool {
+func (bc *Blockchain) ValidProof(height int, nonce int, previousHash [32]byte, transactions []*Transaction, difficulty int) bool {
+	 guessHeader := &BlockHeader{0, nonce, previousHash, TransactionsHash(transactions), nil, height}
+	 return ValidHeaderProof(guessHeader, difficulty)
+}
+
+// ValidHeaderProof checks the proof of work of a header. The work is done
+// with a zero timestamp, so only the nonce, parent and transactions count.
+func ValidHeaderProof(h *BlockHeader, difficulty int) bool {
 	 zeros := strings.Repeat("0", difficulty)
-	 guessBlock := Block{0, nonce, previousHash, transactions}
-	 guessHashStr := fmt.Sprintf("%x", guessBlock.Hash())
+	 guessHeader := BlockHeader{0, h.nonce, h.previousHash, h.transactionsHash, h.extraData, h.height}
+	 guessHashStr := fmt.Sprintf("%x", guessHeader.Hash())
 	 return guessHashStr[:difficulty] == zeros
 }
 
-func (bc *Blockchain) ProofOfWork() int {
-	transactions  := bc.CopyTransactionPool()
-	previousHash := bc.LastBlock().Hash()
+func (bc *Blockchain) ProofOfWork(height int, previousHash [32]byte, transactions []*Transaction) int {
 	nonce := 0
-	for !bc.ValidProof(nonce, previousHash, transactions, MINING_DIFFICULTY){
+	for !bc.ValidProof(height, nonce, previousHash, transactions, bc.params.MiningDifficulty){
 		nonce += 1
 	}
 	return nonce
 }
 
+// Mining works on the current tip without holding the chain lock, so the
+// node keeps serving while it mines. The block is dropped if a neighbour's
+// block arrives first.
 func (bc *Blockchain) Mining() bool {
-	bc.mux.Lock()
-	defer bc.mux.Unlock()
+	bc.muxMining.Lock()
+	defer bc.muxMining.Unlock()
 
-	if len(bc.transactionPool) == 0 {
+	if bc.mempool.Len() == 0 {
+		return false
+	}
+	if bc.syncer.IsSyncing() {
+		log.Println("action=mining, status=syncing")
 		return false
 	}
 
-	bc.AddTransaction(MINING_SENDER, bc.blockchainAddress, MINING_REWARD, nil, nil)
-	nonce := bc.ProofOfWork()
-	previousHash := bc.LastBlock().Hash()
-	bc.CreateBlock(nonce, previousHash)
+	// The miner collects the fees of every transaction it confirms.
+	// Read the tip first: if a block lands while the pool is checked
+	// against the chain, the mined block is stale and dropped.
+	tip := bc.LastBlock()
+	transactions := bc.CopyTransactionPool()
+	if len(transactions) == 0 {
+		return false
+	}
+	reward := bc.params.MiningReward
+	for _, t := range transactions {
+		reward += t.fee
+	}
+	transactions = append(transactions, NewTransaction(bc.params.MiningSender, bc.blockchainAddress, reward, 0, 0))
+	start := time.Now()
+	nonce := bc.ProofOfWork(tip.height+1, tip.Hash(), transactions)
+	bc.recordHashes(uint64(nonce) + 1, time.Since(start))
+	b := bc.CreateBlock(nonce, tip.Hash(), transactions)
+	if b == nil {
+		log.Println("action=mining, status=stale")
+		return false
+	}
 	log.Println("action=mining, status=success")
+	bc.events.Publish(&Event{Type: EventBlockMined, Block: b})
+	bc.gossip.Announce([]InvItem{{InvTypeBlock, hashString(b.Hash())}}, "")
 	return true
 }
 
+func (bc *Blockchain) recordHashes(hashes uint64, elapsed time.Duration) {
+	bc.muxHashRate.Lock()
+	defer bc.muxHashRate.Unlock()
+	bc.hashes += hashes
+	if elapsed > 0 {
+		bc.hashRate = float64(hashes) / elapsed.Seconds()
+	}
+}
+
+// HashRate is the hashes a second of the last proof of work.
+func (bc *Blockchain) HashRate() float64 {
+	bc.muxHashRate.Lock()
+	defer bc.muxHashRate.Unlock()
+	return bc.hashRate
+}
+
+// Hashes counts the hashes tried by every proof of work so far.
+func (bc *Blockchain) Hashes() uint64 {
+	bc.muxHashRate.Lock()
+	defer bc.muxHashRate.Unlock()
+	return bc.hashes
+}
+
+// StartMining mines now and then every MiningTimerSec until StopMining. It
+// does nothing if mining is already running.
 func (bc *Blockchain) StartMining() {
+	bc.muxMiningTimer.Lock()
+	defer bc.muxMiningTimer.Unlock()
+	if bc.miningTimer == nil {
+		bc.miningTimer = time.AfterFunc(0, bc.mineOnTimer)
+	}
+}
+
+func (bc *Blockchain) mineOnTimer() {
 	bc.Mining()
-	_ = time.AfterFunc(time.Second * MINING_TIMER_SEC, bc.StartMining)
+	bc.muxMiningTimer.Lock()
+	defer bc.muxMiningTimer.Unlock()
+	if bc.miningTimer != nil {
+		bc.miningTimer = time.AfterFunc(time.Second * time.Duration(bc.params.MiningTimerSec), bc.mineOnTimer)
+	}
+}
+
+func (bc *Blockchain) StopMining() {
+	bc.muxMiningTimer.Lock()
+	defer bc.muxMiningTimer.Unlock()
+	if bc.miningTimer != nil {
+		bc.miningTimer.Stop()
+		bc.miningTimer = nil
+	}
+}
+
+func (bc *Blockchain) IsMining() bool {
+	bc.muxMiningTimer.Lock()
+	defer bc.muxMiningTimer.Unlock()
+	return bc.miningTimer != nil
 }
 
 func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string) float64 {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.calculateTotalAmount(blockchainAddress)
+}
+
+func (bc *Blockchain) calculateTotalAmount(blockchainAddress string) float64 {
 	var totalAmount float64 = 0.0
 	for _, b := range bc.chain {
 		for _, t := range b.transactions {
@@ -272,15 +1021,15 @@ func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string) float64 {
 				totalAmount += value
 			}
 			if blockchainAddress == t.senderAddress {
-				totalAmount -= value
+				totalAmount -= value + t.fee
 			}
 		}
 	}
 	return totalAmount
 }
 
-func NewTransaction(sender string, receiver string, value float64) *Transaction {
-	return &Transaction{sender, receiver, value}
+func NewTransaction(sender string, receiver string, value float64, fee float64, nonce uint64) *Transaction {
+	return &Transaction{senderAddress: sender, receiverAddress: receiver, value: value, fee: fee, nonce: nonce}
 }
 
 func (t *Transaction) Print() {
@@ -288,6 +1037,30 @@ func (t *Transaction) Print() {
 	fmt.Printf(" sender_address		%s\n", t.senderAddress)
 	fmt.Printf(" receiver_address	%s\n", t.receiverAddress)
 	fmt.Printf(" value			%.18f\n", t.value)
+	fmt.Printf(" fee			%.18f\n", t.fee)
+	fmt.Printf(" nonce			%d\n", t.nonce)
+}
+
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	var v struct{
+		Sender		*string		`json:"sender_address"`
+		Receiver	*string		`json:"receiver_address"`
+		Value 		*float64	`json:"value"`
+		Fee			float64		`json:"fee"`
+		Nonce		uint64		`json:"nonce"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Sender == nil || v.Receiver == nil || v.Value == nil {
+		return fmt.Errorf("transaction is missing field(s)")
+	}
+	t.senderAddress = *v.Sender
+	t.receiverAddress = *v.Receiver
+	t.value = *v.Value
+	t.fee = v.Fee
+	t.nonce = v.Nonce
+	return nil
 }
 
 func (t *Transaction) MarshalJSON() ([]byte, error) {
@@ -295,10 +1068,14 @@ func (t *Transaction) MarshalJSON() ([]byte, error) {
 		Sender		string		`json:"sender_address"`
 		Receiver	string		`json:"receiver_address"`
 		Value 		float64		`json:"value"`
+		Fee			float64		`json:"fee,omitempty"`
+		Nonce		uint64		`json:"nonce"`
 	}{
 		Sender:		t.senderAddress,
 		Receiver:	t.receiverAddress,
 		Value:		t.value,
+		Fee:		t.fee,
+		Nonce:		t.nonce,
 	})
 }
 
@@ -307,10 +1084,28 @@ type TransactionRequest struct {
 	ReceiverAddress *string 	`json:"receiver_address"`
 	SenderPublicKey	*string		`json:"sender_public_key"`
 	Value			*float64	`json:"value"`
+	Fee				*float64	`json:"fee,omitempty"`
+	Nonce			*uint64		`json:"nonce,omitempty"`
 	Signature 		*string		`json:"signature"`
 
 }
 
+// FeeValue is the fee of the request, zero when it has none.
+func (tr *TransactionRequest) FeeValue() float64 {
+	if tr.Fee == nil {
+		return 0
+	}
+	return *tr.Fee
+}
+
+// NonceValue is the nonce of the request, zero when it has none.
+func (tr *TransactionRequest) NonceValue() uint64 {
+	if tr.Nonce == nil {
+		return 0
+	}
+	return *tr.Nonce
+}
+
 func (tr *TransactionRequest) Validate () bool {
 	if tr.SenderAddress == nil ||
 		tr.ReceiverAddress == nil ||
@@ -322,6 +1117,19 @@ func (tr *TransactionRequest) Validate () bool {
 	return true
 }
 
+// Keys parses the sender's public key and the signature of the request.
+func (tr *TransactionRequest) Keys() (*ecdsa.PublicKey, *utils.Signature, error) {
+	publicKey, err := utils.PublicKeyFromString(*tr.SenderPublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	signature, err := utils.SignatureFromString(*tr.Signature)
+	if err != nil {
+		return nil, nil, err
+	}
+	return publicKey, signature, nil
+}
+
 type AmountResponse struct {
 	Amount	float64	`json:"amount"`
 }
@@ -0,0 +1,183 @@
+package block
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/palmcivet7/go-blockchain/wallet"
+)
+
+// newTestBlockchain returns a devnet chain whose genesis block gives funds
+// to a new wallet.
+func newTestBlockchain(t *testing.T, funds float64) (*Blockchain, *wallet.Wallet) {
+	t.Helper()
+	w := wallet.NewWallet(DevnetParams.AddressVersion)
+	params := *DevnetParams
+	params.GenesisAllocations = map[string]float64{w.BlockchainAddress(): funds}
+	miner := wallet.NewWallet(params.AddressVersion)
+	peers := NewPeerManager(PEER_BAN_THRESHOLD, time.Hour)
+	mempool := NewMempool(MEMPOOL_MAX_COUNT, MEMPOOL_MAX_BYTES, MEMPOOL_MAX_PER_SENDER, time.Hour)
+	return NewBlockchain(miner.BlockchainAddress(), 0, peers, mempool, &params), w
+}
+
+func addSigned(bc *Blockchain, w *wallet.Wallet, value float64, fee float64, nonce uint64) error {
+	receiver := "receiver"
+	s := wallet.NewTransaction(w.PrivateKey(), w.PublicKey(), w.BlockchainAddress(), receiver, value, fee, nonce).
+		GenerateSignature(bc.params.ChainID)
+	return bc.AddTransaction(w.BlockchainAddress(), receiver, value, fee, nonce, w.PublicKey(), s)
+}
+
+func TestAddTransactionConcurrentSpends(t *testing.T) {
+	bc, w := newTestBlockchain(t, 10)
+
+	// Each transaction alone is covered by the balance, any two are not.
+	const senders = 16
+	var wg sync.WaitGroup
+	errs := make([]error, senders)
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = addSigned(bc, w, 6, 0, uint64(i))
+		}(i)
+	}
+	wg.Wait()
+
+	accepted := 0
+	for _, err := range errs {
+		switch err {
+		case nil:
+			accepted++
+		case ErrInsufficientFunds:
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if accepted != 1 {
+		t.Fatalf("accepted %d transactions, want 1", accepted)
+	}
+	if n := bc.mempool.Len(); n != 1 {
+		t.Fatalf("mempool has %d transactions, want 1", n)
+	}
+}
+
+func TestAddTransactionConcurrentWithMining(t *testing.T) {
+	bc, w := newTestBlockchain(t, 100)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			addSigned(bc, w, 9, 1, uint64(i))
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				bc.Mining()
+			}
+		}
+	}()
+	wg.Wait()
+	close(done)
+	bc.Mining()
+
+	spent := 100 - bc.CalculateTotalAmount(w.BlockchainAddress())
+	for _, tx := range bc.TransactionPool() {
+		spent += tx.value + tx.fee
+	}
+	if spent > 100 {
+		t.Fatalf("confirmed and pending transactions spend %f of 100", spent)
+	}
+}
+
+func TestMiningSkipsUnfundedTransactions(t *testing.T) {
+	bc, w := newTestBlockchain(t, 10)
+	if err := addSigned(bc, w, 6, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Admitted while the sender seemed richer, as from a neighbour on
+	// another chain.
+	if err := bc.mempool.Add(NewTransaction(w.BlockchainAddress(), "receiver", 6, 0, 1), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bc.Mining() {
+		t.Fatal("nothing was mined")
+	}
+	if balance := bc.CalculateTotalAmount(w.BlockchainAddress()); balance != 4 {
+		t.Fatalf("balance is %f after mining, want 4", balance)
+	}
+	if n := len(bc.LastBlock().transactions); n != 2 {
+		t.Fatalf("block has %d transactions, want the funded one and the reward", n)
+	}
+}
+
+func TestMiningSkipsConfirmedNonces(t *testing.T) {
+	bc, w := newTestBlockchain(t, 10)
+	if err := addSigned(bc, w, 1, 0.1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bc.Mining() {
+		t.Fatal("nothing was mined")
+	}
+	// Another version of the now confirmed nonce 0.
+	if err := bc.mempool.Add(NewTransaction(w.BlockchainAddress(), "receiver", 1, 0.5, 0), 100); err != nil {
+		t.Fatal(err)
+	}
+	if bc.Mining() {
+		t.Fatal("mined a transaction reusing a confirmed nonce")
+	}
+}
+
+func TestAddTransactionRejectsInvalidAmounts(t *testing.T) {
+	bc, w := newTestBlockchain(t, 50)
+	for _, c := range []struct {
+		value, fee float64
+		err        error
+	}{
+		{-40, 0, ErrInvalidValue},
+		{0, 0, ErrInvalidValue},
+		{math.NaN(), 0, ErrInvalidValue},
+		{math.Inf(1), 0, ErrInvalidValue},
+		{1, -1, ErrInvalidFee},
+		{1, math.NaN(), ErrInvalidFee},
+		{1, math.Inf(1), ErrInvalidFee},
+	} {
+		if err := addSigned(bc, w, c.value, c.fee, 0); err != c.err {
+			t.Errorf("value %v, fee %v: got %v, want %v", c.value, c.fee, err, c.err)
+		}
+	}
+	if n := bc.mempool.Len(); n != 0 {
+		t.Fatalf("mempool has %d transactions, want none", n)
+	}
+}
+
+func TestMiningSkipsInvalidAmounts(t *testing.T) {
+	bc, w := newTestBlockchain(t, 50)
+	if err := addSigned(bc, w, 1, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	// A negative transfer would take coins from the receiver.
+	victim := wallet.NewWallet(DevnetParams.AddressVersion)
+	if err := bc.mempool.Add(NewTransaction(victim.BlockchainAddress(), w.BlockchainAddress(), -40, 0, 0), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bc.Mining() {
+		t.Fatal("nothing was mined")
+	}
+	if n := len(bc.LastBlock().transactions); n != 2 {
+		t.Fatalf("block has %d transactions, want the valid one and the reward", n)
+	}
+	if balance := bc.CalculateTotalAmount(w.BlockchainAddress()); balance != 49 {
+		t.Fatalf("balance is %f after mining, want 49", balance)
+	}
+}
@@ -0,0 +1,116 @@
+package block
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	BROADCAST_WORKERS      = 8
+	BROADCAST_QUEUE_SIZE   = 256
+	BROADCAST_MAX_ATTEMPTS = 4
+	BROADCAST_BACKOFF_MS   = 250
+	// A Retry-After longer than this is cut short, so one peer cannot hold
+	// its queue for long.
+	BROADCAST_MAX_RETRY_AFTER_SEC = 30
+)
+
+type outboundMessage struct {
+	method string
+	path   string
+	body   []byte
+}
+
+// Broadcaster delivers messages to neighbours in the background. Every peer
+// has its own queue so a slow peer only delays its own messages, and at most
+// a fixed number of requests are in flight across all peers.
+type Broadcaster struct {
+	bc      *Blockchain
+	queues  map[string]chan *outboundMessage
+	workers chan struct{}
+	mux     sync.Mutex
+}
+
+func NewBroadcaster(bc *Blockchain, workers int) *Broadcaster {
+	return &Broadcaster{
+		bc:      bc,
+		queues:  make(map[string]chan *outboundMessage),
+		workers: make(chan struct{}, workers),
+	}
+}
+
+// Send queues a message for peer without waiting for it to be delivered.
+// The message is dropped if the peer's queue is full.
+func (b *Broadcaster) Send(peer string, method string, path string, body []byte) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	queue, ok := b.queues[peer]
+	if !ok {
+		queue = make(chan *outboundMessage, BROADCAST_QUEUE_SIZE)
+		b.queues[peer] = queue
+		go b.drain(peer, queue)
+	}
+
+	select {
+	case queue <- &outboundMessage{method, path, body}:
+	default:
+		log.Printf("ERROR: broadcast queue for %s is full, dropping %s %s", peer, method, path)
+	}
+}
+
+// Remove drops peer's queue and the messages waiting in it, and stops its
+// drain goroutine.
+func (b *Broadcaster) Remove(peer string) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if queue, ok := b.queues[peer]; ok {
+		delete(b.queues, peer)
+		close(queue)
+	}
+}
+
+// queued reports whether queue is still peer's.
+func (b *Broadcaster) queued(peer string, queue chan *outboundMessage) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.queues[peer] == queue
+}
+
+func (b *Broadcaster) drain(peer string, queue chan *outboundMessage) {
+	for msg := range queue {
+		if b.bc.peers.IsBanned(peer) || !b.queued(peer, queue) {
+			continue
+		}
+		b.deliver(peer, msg)
+	}
+}
+
+func (b *Broadcaster) deliver(peer string, msg *outboundMessage) {
+	backoff := time.Millisecond * BROADCAST_BACKOFF_MS
+	for attempt := 1; attempt <= BROADCAST_MAX_ATTEMPTS; attempt++ {
+		b.workers <- struct{}{}
+		err := b.bc.requestNeighbour(msg.method, peer, msg.path, msg.body, nil)
+		<-b.workers
+		if err == nil {
+			return
+		}
+		// The peer understood and refused the message, sending it again
+		// will not help. A rate limited one may take it later.
+		se, ok := err.(*NeighbourStatusError)
+		if ok && se.StatusCode < http.StatusInternalServerError && se.StatusCode != http.StatusTooManyRequests {
+			log.Printf("ERROR: %v", err)
+			return
+		}
+		log.Printf("ERROR: broadcast to %s, attempt %d/%d: %v", peer, attempt, BROADCAST_MAX_ATTEMPTS, err)
+		if attempt < BROADCAST_MAX_ATTEMPTS {
+			wait := backoff
+			if ok && se.RetryAfter > wait {
+				wait = min(se.RetryAfter, time.Second*BROADCAST_MAX_RETRY_AFTER_SEC)
+			}
+			time.Sleep(wait)
+			backoff *= 2
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package block
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBroadcasterRemove(t *testing.T) {
+	bc, _ := newTestBlockchain(t, 0)
+	b := bc.broadcaster
+	// Banned, so nothing is delivered.
+	peer := "127.0.0.1:5001"
+	bc.peers.Misbehaving(peer, MisbehaviourBadProofOfWork)
+
+	b.Send(peer, http.MethodPost, "/inv", nil)
+	b.Remove(peer)
+	b.Remove(peer)
+	if n := len(b.queues); n != 0 {
+		t.Fatalf("%d queues left after removing the only peer", n)
+	}
+
+	b.Send(peer, http.MethodPost, "/inv", nil)
+	if n := len(b.queues); n != 1 {
+		t.Fatalf("%d queues after sending to a removed peer again, want 1", n)
+	}
+}
+
+// statusServer answers each request with the next of statuses, and the
+// Retry-After header retry while it is not empty.
+func statusServer(t *testing.T, retry string, statuses ...int) (string, *int32) {
+	t.Helper()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		status := statuses[len(statuses)-1]
+		if int(n) <= len(statuses) {
+			status = statuses[n-1]
+		}
+		if retry != "" {
+			w.Header().Set("Retry-After", retry)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://"), &requests
+}
+
+func TestBroadcasterRetries(t *testing.T) {
+	bc, _ := newTestBlockchain(t, 0)
+	msg := &outboundMessage{http.MethodPost, "/inv", nil}
+	for _, c := range []struct {
+		name     string
+		statuses []int
+		requests int32
+	}{
+		{"refused", []int{http.StatusBadRequest}, 1},
+		{"server error", []int{http.StatusServiceUnavailable, http.StatusOK}, 2},
+		{"rate limited", []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK}, 3},
+		{"always failing", []int{http.StatusInternalServerError}, BROADCAST_MAX_ATTEMPTS},
+	} {
+		peer, requests := statusServer(t, "", c.statuses...)
+		bc.broadcaster.deliver(peer, msg)
+		if n := atomic.LoadInt32(requests); n != c.requests {
+			t.Errorf("%s: %d requests, want %d", c.name, n, c.requests)
+		}
+	}
+}
+
+func TestBroadcasterHonoursRetryAfter(t *testing.T) {
+	bc, _ := newTestBlockchain(t, 0)
+	peer, requests := statusServer(t, "1", http.StatusTooManyRequests, http.StatusOK)
+	start := time.Now()
+	bc.broadcaster.deliver(peer, &outboundMessage{http.MethodPost, "/inv", nil})
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Fatalf("%d requests, want 2", n)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("retried after %v, sooner than Retry-After asked", elapsed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if d := retryAfter("120"); d != 2*time.Minute {
+		t.Errorf("seconds: %v", d)
+	}
+	if d := retryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); d <= 58*time.Second || d > time.Minute {
+		t.Errorf("date: %v", d)
+	}
+	for _, v := range []string{"", "-1", "soon"} {
+		if d := retryAfter(v); d != 0 {
+			t.Errorf("%q: %v", v, d)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package block
+
+import (
+	"sync"
+	"time"
+)
+
+const EVENT_SUBSCRIPTION_BUFFER = 256
+
+type EventType int
+
+const (
+	EventNewTransaction EventType = iota
+	EventTransactionRejected
+	EventBlockMined
+	EventBlockConnected
+	EventBlockDisconnected
+	EventPeerAdded
+	EventPeerRemoved
+)
+
+func (et EventType) String() string {
+	switch et {
+	case EventNewTransaction:
+		return "new_transaction"
+	case EventTransactionRejected:
+		return "transaction_rejected"
+	case EventBlockMined:
+		return "block_mined"
+	case EventBlockConnected:
+		return "block_connected"
+	case EventBlockDisconnected:
+		return "block_disconnected"
+	case EventPeerAdded:
+		return "peer_added"
+	case EventPeerRemoved:
+		return "peer_removed"
+	}
+	return "unknown"
+}
+
+// Event carries what happened along with the transaction, block or peer it
+// happened to. Err is set on rejected transactions.
+type Event struct {
+	Type        EventType
+	Time        time.Time
+	Transaction *Transaction
+	Block       *Block
+	Peer        string
+	Err         error
+}
+
+// Subscription receives the events of the types it subscribed to. A
+// subscriber that falls behind misses events rather than stalling the node,
+// Dropped counts how many it missed.
+type Subscription struct {
+	id      int
+	bus     *EventBus
+	types   map[EventType]bool
+	events  chan *Event
+	dropped int
+	mux     sync.Mutex
+}
+
+func (s *Subscription) Events() <-chan *Event {
+	return s.events
+}
+
+func (s *Subscription) Dropped() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.dropped
+}
+
+// Unsubscribe stops delivery and closes the events channel.
+func (s *Subscription) Unsubscribe() {
+	s.bus.unsubscribe(s)
+}
+
+func (s *Subscription) deliver(e *Event) {
+	select {
+	case s.events <- e:
+	default:
+		s.mux.Lock()
+		s.dropped++
+		s.mux.Unlock()
+	}
+}
+
+// EventBus fans chain, mempool and peer events out to subscribers. Publish
+// never blocks.
+type EventBus struct {
+	subscriptions map[int]*Subscription
+	next          int
+	mux           sync.RWMutex
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscriptions: make(map[int]*Subscription)}
+}
+
+// Subscribe returns a subscription buffering up to buffer events of the
+// given types, or of every type if none are given.
+func (eb *EventBus) Subscribe(buffer int, types ...EventType) *Subscription {
+	eb.mux.Lock()
+	defer eb.mux.Unlock()
+
+	s := &Subscription{
+		id:     eb.next,
+		bus:    eb,
+		types:  make(map[EventType]bool, len(types)),
+		events: make(chan *Event, buffer),
+	}
+	for _, t := range types {
+		s.types[t] = true
+	}
+	eb.next++
+	eb.subscriptions[s.id] = s
+	return s
+}
+
+func (eb *EventBus) unsubscribe(s *Subscription) {
+	eb.mux.Lock()
+	defer eb.mux.Unlock()
+
+	if _, ok := eb.subscriptions[s.id]; ok {
+		delete(eb.subscriptions, s.id)
+		close(s.events)
+	}
+}
+
+func (eb *EventBus) Publish(e *Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	eb.mux.RLock()
+	defer eb.mux.RUnlock()
+	for _, s := range eb.subscriptions {
+		if len(s.types) == 0 || s.types[e.Type] {
+			s.deliver(e)
+		}
+	}
+}
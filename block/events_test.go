@@ -0,0 +1,68 @@
+package block
+
+import "testing"
+
+// received drains what s has buffered.
+func received(s *Subscription) []EventType {
+	types := make([]EventType, 0)
+	for {
+		select {
+		case e := <-s.Events():
+			types = append(types, e.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func TestEventBusFiltersTypes(t *testing.T) {
+	eb := NewEventBus()
+	blocks := eb.Subscribe(EVENT_SUBSCRIPTION_BUFFER, EventBlockConnected, EventBlockDisconnected)
+	all := eb.Subscribe(EVENT_SUBSCRIPTION_BUFFER)
+	for _, et := range []EventType{EventNewTransaction, EventBlockConnected, EventPeerAdded, EventBlockDisconnected} {
+		eb.Publish(&Event{Type: et})
+	}
+
+	if got := received(blocks); len(got) != 2 || got[0] != EventBlockConnected || got[1] != EventBlockDisconnected {
+		t.Errorf("block subscriber got %v", got)
+	}
+	if got := received(all); len(got) != 4 {
+		t.Errorf("subscriber to every type got %v", got)
+	}
+}
+
+func TestEventBusDropsWhenFull(t *testing.T) {
+	eb := NewEventBus()
+	slow := eb.Subscribe(2)
+	fast := eb.Subscribe(EVENT_SUBSCRIPTION_BUFFER)
+	for i := 0; i < 5; i++ {
+		eb.Publish(&Event{Type: EventNewTransaction})
+	}
+
+	if got := received(slow); len(got) != 2 {
+		t.Errorf("full subscriber got %d events, want the 2 it buffers", len(got))
+	}
+	if dropped := slow.Dropped(); dropped != 3 {
+		t.Errorf("dropped %d events, want 3", dropped)
+	}
+	if got := received(fast); len(got) != 5 || fast.Dropped() != 0 {
+		t.Errorf("a slow subscriber cost another %d events", 5-len(got))
+	}
+}
+
+func TestEventBusUnsubscribe(t *testing.T) {
+	eb := NewEventBus()
+	s := eb.Subscribe(EVENT_SUBSCRIPTION_BUFFER)
+	eb.Publish(&Event{Type: EventPeerAdded})
+	s.Unsubscribe()
+	// Publishing to a closed subscription would panic.
+	eb.Publish(&Event{Type: EventPeerRemoved})
+	s.Unsubscribe()
+
+	if e, ok := <-s.Events(); !ok || e.Type != EventPeerAdded {
+		t.Fatal("an event buffered before unsubscribing was lost")
+	}
+	if _, ok := <-s.Events(); ok {
+		t.Fatal("the events channel is open after unsubscribing")
+	}
+}
@@ -0,0 +1,64 @@
+package block
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const MAX_GENESIS_EXTRA_DATA = 256
+
+// GenesisSpec describes block 0 of a network. Every node given the same spec
+// builds the same genesis block.
+type GenesisSpec struct {
+	Timestamp   *int64             `json:"timestamp"`
+	Difficulty  *int               `json:"difficulty"`
+	ExtraData   string             `json:"extra_data"`
+	Allocations map[string]float64 `json:"allocations"`
+}
+
+func LoadGenesisSpec(path string) (*GenesisSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec GenesisSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &spec, nil
+}
+
+func (gs *GenesisSpec) Validate() error {
+	if gs.Timestamp == nil {
+		return fmt.Errorf("genesis timestamp is missing")
+	}
+	// At 0 every nonce is a valid proof of work.
+	if gs.Difficulty != nil && (*gs.Difficulty < 1 || *gs.Difficulty > 64) {
+		return fmt.Errorf("genesis difficulty must be between 1 and 64")
+	}
+	if len(gs.ExtraData) > MAX_GENESIS_EXTRA_DATA {
+		return fmt.Errorf("genesis extra data must be at most %d bytes", MAX_GENESIS_EXTRA_DATA)
+	}
+	for address, amount := range gs.Allocations {
+		if address == "" || amount <= 0 {
+			return fmt.Errorf("genesis allocation %q must be to an address and positive", address)
+		}
+	}
+	return nil
+}
+
+// WithGenesis returns a copy of the parameters using spec for block 0.
+func (p *ChainParams) WithGenesis(spec *GenesisSpec) *ChainParams {
+	params := *p
+	params.GenesisTimestamp = *spec.Timestamp
+	if spec.Difficulty != nil {
+		params.MiningDifficulty = *spec.Difficulty
+	}
+	params.GenesisExtraData = []byte(spec.ExtraData)
+	params.GenesisAllocations = spec.Allocations
+	return &params
+}
@@ -0,0 +1,57 @@
+package block
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeGenesisSpec(t *testing.T, spec string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	if err := os.WriteFile(path, []byte(spec), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGenesisIsDeterministic(t *testing.T) {
+	path := writeGenesisSpec(t, `{"timestamp": 1698796800000000000, "difficulty": 2, "extra_data": "ci",
+		"allocations": {"alice": 10, "bob": 20, "carol": 30, "dave": 40}}`)
+	spec, err := LoadGenesisSpec(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := DevnetParams.WithGenesis(spec).Genesis().Hash()
+	for i := 0; i < 10; i++ {
+		if h := DevnetParams.WithGenesis(spec).Genesis().Hash(); h != hash {
+			t.Fatalf("the same spec built genesis %x, then %x", hash, h)
+		}
+	}
+
+	difficulty := 3
+	spec.Difficulty = &difficulty
+	if DevnetParams.WithGenesis(spec).Genesis().Hash() == hash {
+		t.Fatal("the genesis block does not commit to the difficulty")
+	}
+}
+
+func TestLoadGenesisSpecRejectsBadSpecs(t *testing.T) {
+	for name, spec := range map[string]string{
+		"malformed":          `{"timestamp": `,
+		"no timestamp":       `{"difficulty": 1}`,
+		"zero difficulty":    `{"timestamp": 1, "difficulty": 0}`,
+		"huge difficulty":    `{"timestamp": 1, "difficulty": 65}`,
+		"long extra data":    `{"timestamp": 1, "extra_data": "` + strings.Repeat("x", MAX_GENESIS_EXTRA_DATA+1) + `"}`,
+		"negative premine":   `{"timestamp": 1, "allocations": {"alice": -1}}`,
+		"premine to nothing": `{"timestamp": 1, "allocations": {"": 1}}`,
+	} {
+		if _, err := LoadGenesisSpec(writeGenesisSpec(t, spec)); err == nil {
+			t.Errorf("%s: spec was accepted", name)
+		}
+	}
+	if _, err := LoadGenesisSpec(writeGenesisSpec(t, `{"timestamp": 1, "difficulty": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,291 @@
+package block
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/palmcivet7/go-blockchain/wire"
+)
+
+const (
+	InvTypeTransaction = "tx"
+	InvTypeBlock       = "block"
+
+	GOSSIP_SEEN_CACHE_SIZE = 10000
+	// Announcements fetched at once from HTTP peers. Each is a request to
+	// the announcing node, so further ones are dropped rather than queued.
+	GOSSIP_MAX_FETCHES = 16
+	// An item requested from one neighbour is not requested from another
+	// until this long has passed without it arriving.
+	GOSSIP_FETCH_TIMEOUT_SEC = 30
+)
+
+type InvItem struct {
+	Type string `json:"type"`
+	Hash string `json:"hash"`
+}
+
+// InvMessage announces the hashes of transactions and blocks a node has.
+type InvMessage struct {
+	Items []InvItem `json:"items"`
+}
+
+// GetDataRequest asks the announcing node for the items it is missing.
+type GetDataRequest struct {
+	Items []InvItem `json:"items"`
+}
+
+type GetDataResponse struct {
+	Transactions []*TransactionRequest `json:"transactions"`
+	Blocks       []*Block              `json:"blocks"`
+}
+
+// SeenCache remembers the most recent inventory items so announcements are
+// neither fetched nor relayed twice.
+type SeenCache struct {
+	items map[InvItem]bool
+	order []InvItem
+	size  int
+	mux   sync.Mutex
+}
+
+func NewSeenCache(size int) *SeenCache {
+	return &SeenCache{items: make(map[InvItem]bool), size: size}
+}
+
+func (sc *SeenCache) Has(item InvItem) bool {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	return sc.items[item]
+}
+
+// Add marks item as seen and reports whether it was new.
+func (sc *SeenCache) Add(item InvItem) bool {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+
+	if sc.items[item] {
+		return false
+	}
+	sc.items[item] = true
+	sc.order = append(sc.order, item)
+	if len(sc.order) > sc.size {
+		delete(sc.items, sc.order[0])
+		sc.order = sc.order[1:]
+	}
+	return true
+}
+
+type Gossip struct {
+	bc      *Blockchain
+	seen    *SeenCache
+	fetches chan struct{}
+	// requested holds when each item being fetched may be requested again.
+	requested map[InvItem]time.Time
+	mux       sync.Mutex
+}
+
+func NewGossip(bc *Blockchain) *Gossip {
+	return &Gossip{
+		bc:        bc,
+		seen:      NewSeenCache(GOSSIP_SEEN_CACHE_SIZE),
+		fetches:   make(chan struct{}, GOSSIP_MAX_FETCHES),
+		requested: make(map[InvItem]time.Time),
+	}
+}
+
+// Announce sends an inv for items to every neighbour except the one they
+// came from, over the wire protocol where connected and HTTP otherwise.
+func (g *Gossip) Announce(items []InvItem, except string) {
+	for _, item := range items {
+		g.seen.Add(item)
+	}
+	m, _ := json.Marshal(&InvMessage{items})
+	for _, n := range g.bc.Neighbours() {
+		if n == except {
+			continue
+		}
+		if g.bc.wire != nil && g.bc.wire.Send(n, &wire.Inv{Items: toWireItems(items)}) == nil {
+			continue
+		}
+		g.bc.broadcaster.Send(n, http.MethodPost, "/inv", m)
+	}
+}
+
+// filterMissing returns the announced items we have neither seen nor
+// requested, and marks them requested. They are only marked seen once they
+// arrive, so an item a neighbour fails to deliver can be fetched from
+// another after GOSSIP_FETCH_TIMEOUT_SEC, or at once if the fetch is
+// forgotten.
+func (g *Gossip) filterMissing(items []InvItem) []InvItem {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	now := time.Now()
+	if len(g.requested) > GOSSIP_SEEN_CACHE_SIZE {
+		for item, retry := range g.requested {
+			if now.After(retry) {
+				delete(g.requested, item)
+			}
+		}
+	}
+	missing := make([]InvItem, 0)
+	for _, item := range items {
+		if item.Type != InvTypeTransaction && item.Type != InvTypeBlock {
+			continue
+		}
+		if retry, ok := g.requested[item]; g.seen.Has(item) || ok && now.Before(retry) {
+			continue
+		}
+		g.requested[item] = now.Add(time.Second * GOSSIP_FETCH_TIMEOUT_SEC)
+		missing = append(missing, item)
+	}
+	return missing
+}
+
+// forget lets items be requested again.
+func (g *Gossip) forget(items []InvItem) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	for _, item := range items {
+		delete(g.requested, item)
+	}
+}
+
+// received marks an item that arrived, whether or not it was accepted, so
+// it is neither fetched again nor relayed twice.
+func (g *Gossip) received(item InvItem) {
+	g.seen.Add(item)
+	g.forget([]InvItem{item})
+}
+
+// HandleInv fetches the announced items we have not seen from the announcing
+// neighbour, accepts the valid ones and relays them on.
+func (g *Gossip) HandleInv(from string, inv *InvMessage) {
+	missing := g.filterMissing(inv.Items)
+	if len(missing) == 0 {
+		return
+	}
+
+	var resp GetDataResponse
+	m, _ := json.Marshal(&GetDataRequest{missing})
+	// Whatever did not arrive may be fetched from the next neighbour that
+	// announces it.
+	defer g.forget(missing)
+	if err := g.bc.postToNeighbour(from, "/getdata", m, &resp); err != nil {
+		log.Printf("ERROR: %v", err)
+		return
+	}
+
+	relay := make([]InvItem, 0)
+	for _, tr := range resp.Transactions {
+		if item, ok := g.receiveTransaction(from, tr); ok {
+			relay = append(relay, item)
+		}
+	}
+	for _, b := range resp.Blocks {
+		if item, ok := g.receiveBlock(from, b); ok {
+			relay = append(relay, item)
+		}
+	}
+	if len(relay) > 0 {
+		g.Announce(relay, from)
+	}
+}
+
+// HandleInvAsync handles inv in the background, or drops it and returns
+// false if GOSSIP_MAX_FETCHES announcements are being fetched already. The
+// dropped items are not marked requested, so a later announcement fetches
+// them.
+func (g *Gossip) HandleInvAsync(from string, inv *InvMessage) bool {
+	select {
+	case g.fetches <- struct{}{}:
+	default:
+		return false
+	}
+	go func() {
+		defer func() { <-g.fetches }()
+		g.HandleInv(from, inv)
+	}()
+	return true
+}
+
+// receiveTransaction adds a transaction sent by a neighbour to the pool and
+// returns the item to relay if it was accepted.
+func (g *Gossip) receiveTransaction(from string, tr *TransactionRequest) (InvItem, bool) {
+	if tr == nil || !tr.Validate() {
+		g.bc.peers.Misbehaving(from, MisbehaviourMalformedJSON)
+		return InvItem{}, false
+	}
+	t := NewTransaction(*tr.SenderAddress, *tr.ReceiverAddress, *tr.Value, tr.FeeValue(), tr.NonceValue())
+	item := InvItem{InvTypeTransaction, hashString(t.Hash())}
+	publicKey, signature, err := tr.Keys()
+	if err != nil {
+		g.bc.peers.Misbehaving(from, MisbehaviourInvalidSignature)
+		g.forget([]InvItem{item})
+		return InvItem{}, false
+	}
+	if err = g.bc.AddTransaction(*tr.SenderAddress, *tr.ReceiverAddress, *tr.Value, tr.FeeValue(), tr.NonceValue(), publicKey, signature); err != nil {
+		// The hash does not cover the signature, so another neighbour
+		// may still deliver the transaction signed.
+		if IsInvalidTransaction(err) {
+			g.bc.peers.Misbehaving(from, MisbehaviourInvalidSignature)
+			g.forget([]InvItem{item})
+			return InvItem{}, false
+		}
+		// An honest neighbour may relay what our mempool has no room for.
+		g.received(item)
+		return InvItem{}, false
+	}
+	g.received(item)
+	return item, true
+}
+
+// receiveBlock connects a block sent by a neighbour and returns the item to
+// relay if it extended our chain.
+func (g *Gossip) receiveBlock(from string, b *Block) (InvItem, bool) {
+	if b == nil {
+		return InvItem{}, false
+	}
+	item := InvItem{InvTypeBlock, hashString(b.Hash())}
+	g.received(item)
+	switch err := g.bc.ConnectBlock(b); err {
+	case nil:
+		return item, true
+	case ErrBadProofOfWork:
+		g.bc.peers.Misbehaving(from, MisbehaviourBadProofOfWork)
+	case ErrUnknownParent:
+		// We are missing blocks, the neighbour may be on a longer chain.
+		go g.bc.syncer.Run()
+	default:
+		if IsInvalidBlock(err) {
+			log.Printf("ERROR: block from %s: %v", from, err)
+			g.bc.peers.Misbehaving(from, MisbehaviourInvalidBlock)
+		}
+	}
+	return InvItem{}, false
+}
+
+func (g *Gossip) GetData(req *GetDataRequest) *GetDataResponse {
+	resp := &GetDataResponse{[]*TransactionRequest{}, []*Block{}}
+	for _, item := range req.Items {
+		hash, err := hashFromString(item.Hash)
+		if err != nil {
+			continue
+		}
+		switch item.Type {
+		case InvTypeTransaction:
+			if t := g.bc.PendingTransaction(hash); t != nil && t.Request() != nil {
+				resp.Transactions = append(resp.Transactions, t.Request())
+			}
+		case InvTypeBlock:
+			if b := g.bc.BlockByHash(hash); b != nil {
+				resp.Blocks = append(resp.Blocks, b)
+			}
+		}
+	}
+	return resp
+}
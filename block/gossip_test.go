@@ -0,0 +1,60 @@
+package block
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilterMissingTracksRequests(t *testing.T) {
+	bc, _ := newTestBlockchain(t, 0)
+	g := bc.gossip
+	a := InvItem{InvTypeTransaction, strings.Repeat("a", 64)}
+	b := InvItem{InvTypeBlock, strings.Repeat("b", 64)}
+
+	if missing := g.filterMissing([]InvItem{a, b, {"other", "c"}}); len(missing) != 2 {
+		t.Fatalf("missing %v, want both known types", missing)
+	}
+	if missing := g.filterMissing([]InvItem{a, b}); len(missing) != 0 {
+		t.Fatalf("missing %v while both are requested", missing)
+	}
+	if g.seen.Has(a) || g.seen.Has(b) {
+		t.Fatal("requested items were marked seen before they arrived")
+	}
+
+	g.forget([]InvItem{a})
+	g.received(b)
+	if missing := g.filterMissing([]InvItem{a, b}); len(missing) != 1 || missing[0] != a {
+		t.Fatalf("missing %v, want only the forgotten item", missing)
+	}
+
+	g.requested[a] = time.Now().Add(-time.Second)
+	if missing := g.filterMissing([]InvItem{a}); len(missing) != 1 {
+		t.Fatal("a request that timed out was not made again")
+	}
+}
+
+func TestHandleInvForgetsUndelivered(t *testing.T) {
+	bc, _ := newTestBlockchain(t, 0)
+	g := bc.gossip
+	item := InvItem{InvTypeTransaction, strings.Repeat("a", 64)}
+
+	// A neighbour that announces but does not deliver, and one that is gone.
+	empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"transactions": [], "blocks": []}`))
+	}))
+	defer empty.Close()
+	gone := httptest.NewServer(http.NotFoundHandler())
+	gone.Close()
+
+	for _, srv := range []*httptest.Server{empty, gone} {
+		from := strings.TrimPrefix(srv.URL, "http://")
+		g.HandleInv(from, &InvMessage{[]InvItem{item}})
+		if missing := g.filterMissing([]InvItem{item}); len(missing) != 1 {
+			t.Fatalf("%s: an item that never arrived cannot be fetched from another neighbour", from)
+		}
+		g.forget([]InvItem{item})
+	}
+}
@@ -0,0 +1,172 @@
+package block
+
+import (
+	"encoding/json"
+)
+
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// txLocation points at a confirmed transaction by block height and its
+// position in the block.
+type txLocation struct {
+	height int
+	index  int
+}
+
+// AddressIndex maps every address to the confirmed transactions that send
+// to or from it, in chain order. It is guarded by the blockchain's lock.
+type AddressIndex struct {
+	entries map[string][]txLocation
+}
+
+func NewAddressIndex() *AddressIndex {
+	return &AddressIndex{entries: make(map[string][]txLocation)}
+}
+
+func (ai *AddressIndex) add(b *Block) {
+	for i, t := range b.transactions {
+		loc := txLocation{b.height, i}
+		ai.entries[t.senderAddress] = append(ai.entries[t.senderAddress], loc)
+		if t.receiverAddress != t.senderAddress {
+			ai.entries[t.receiverAddress] = append(ai.entries[t.receiverAddress], loc)
+		}
+	}
+}
+
+// truncate forgets every transaction at height and above, for when the
+// chain is replaced from that height.
+func (ai *AddressIndex) truncate(height int) {
+	for address, locs := range ai.entries {
+		n := len(locs)
+		for n > 0 && locs[n-1].height >= height {
+			n--
+		}
+		if n == 0 {
+			delete(ai.entries, address)
+		} else {
+			ai.entries[address] = locs[:n]
+		}
+	}
+}
+
+// ConfirmedNonce is the lowest nonce address may still use, one more than
+// the highest nonce it has confirmed.
+func (bc *Blockchain) ConfirmedNonce(address string) uint64 {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.confirmedNonce(address)
+}
+
+func (bc *Blockchain) confirmedNonce(address string) uint64 {
+	var next uint64
+	for _, loc := range bc.index.entries[address] {
+		t := bc.chain[loc.height].transactions[loc.index]
+		if t.senderAddress == address && t.nonce >= next {
+			next = t.nonce + 1
+		}
+	}
+	return next
+}
+
+// PendingNonce is the nonce for the next new transaction of address, after
+// both its confirmed and its pending transactions.
+func (bc *Blockchain) PendingNonce(address string) uint64 {
+	next := bc.ConfirmedNonce(address)
+	if pending, ok := bc.mempool.HighestNonce(address); ok && pending >= next {
+		next = pending + 1
+	}
+	return next
+}
+
+// ConfirmedTransaction finds a transaction in the chain by hash, along with
+// the block confirming it. Both are nil if it is not in the chain.
+func (bc *Blockchain) ConfirmedTransaction(hash [32]byte) (*Transaction, *Block) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
+	for i := len(bc.chain) - 1; i >= 0; i-- {
+		for _, t := range bc.chain[i].transactions {
+			if t.Hash() == hash {
+				return t, bc.chain[i]
+			}
+		}
+	}
+	return nil, nil
+}
+
+// AddressTransaction is a confirmed transaction as seen from one address.
+type AddressTransaction struct {
+	transaction   *Transaction
+	direction     string
+	blockHeight   int
+	blockHash     [32]byte
+	timestamp     int64
+	confirmations int
+}
+
+func (at *AddressTransaction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Hash            string  `json:"hash"`
+		SenderAddress   string  `json:"sender_address"`
+		ReceiverAddress string  `json:"receiver_address"`
+		Value           float64 `json:"value"`
+		Fee             float64 `json:"fee"`
+		Nonce           uint64  `json:"nonce"`
+		Direction       string  `json:"direction"`
+		BlockHeight     int     `json:"block_height"`
+		BlockHash       string  `json:"block_hash"`
+		Timestamp       int64   `json:"timestamp"`
+		Confirmations   int     `json:"confirmations"`
+	}{
+		Hash:            hashString(at.transaction.Hash()),
+		SenderAddress:   at.transaction.senderAddress,
+		ReceiverAddress: at.transaction.receiverAddress,
+		Value:           at.transaction.value,
+		Fee:             at.transaction.fee,
+		Nonce:           at.transaction.nonce,
+		Direction:       at.direction,
+		BlockHeight:     at.blockHeight,
+		BlockHash:       hashString(at.blockHash),
+		Timestamp:       at.timestamp,
+		Confirmations:   at.confirmations,
+	})
+}
+
+// AddressTransactions returns up to limit of the address's transactions,
+// newest first, skipping the first offset. An empty direction matches both
+// directions. It also returns how many transactions match in total.
+func (bc *Blockchain) AddressTransactions(address string, direction string, offset int, limit int) ([]*AddressTransaction, int) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
+	locs := bc.index.entries[address]
+	result := make([]*AddressTransaction, 0)
+	total := 0
+	for i := len(locs) - 1; i >= 0; i-- {
+		b := bc.chain[locs[i].height]
+		t := b.transactions[locs[i].index]
+		d := DirectionIn
+		if t.senderAddress == address {
+			d = DirectionOut
+		}
+		if direction != "" && direction != d {
+			continue
+		}
+		total++
+		if total <= offset || len(result) >= limit {
+			continue
+		}
+		result = append(result, &AddressTransaction{
+			transaction:   t,
+			direction:     d,
+			blockHeight:   b.height,
+			blockHash:     b.Hash(),
+			timestamp:     b.timestamp,
+			confirmations: len(bc.chain) - b.height,
+		})
+	}
+	return result, total
+}
@@ -0,0 +1,90 @@
+package block
+
+import (
+	"errors"
+)
+
+var (
+	ErrInvalidCoinbase     = errors.New("block must end with one mining reward of the reward plus its fees")
+	ErrInvalidBlockNonce   = errors.New("block reuses a confirmed or repeated nonce")
+	ErrInvalidBlockFunds   = errors.New("block spends more than a sender's balance")
+	ErrInvalidBlockAmounts = errors.New("block has a transaction with an invalid value or fee")
+)
+
+// IsInvalidBlock reports whether err means a block breaks the rules its
+// transactions are checked by, so whoever sent it misbehaved.
+func IsInvalidBlock(err error) bool {
+	return err == ErrInvalidCoinbase || err == ErrInvalidBlockNonce || err == ErrInvalidBlockFunds || err == ErrInvalidBlockAmounts
+}
+
+// ledger holds the balance and next nonce of every address after some
+// blocks, so that a block can be checked against the state it builds on.
+type ledger struct {
+	balances   map[string]float64
+	nextNonces map[string]uint64
+}
+
+func newLedger(blocks []*Block) *ledger {
+	l := &ledger{make(map[string]float64), make(map[string]uint64)}
+	for _, b := range blocks {
+		l.apply(b)
+	}
+	return l
+}
+
+// apply adds up b's transactions in the order CalculateTotalAmount does, so
+// that balances come out the same to the last bit.
+func (l *ledger) apply(b *Block) {
+	for _, t := range b.transactions {
+		l.balances[t.receiverAddress] += t.value
+		l.balances[t.senderAddress] -= t.value + t.fee
+		if t.nonce >= l.nextNonces[t.senderAddress] {
+			l.nextNonces[t.senderAddress] = t.nonce + 1
+		}
+	}
+}
+
+// check replays b's transactions by the rules Mining builds blocks with:
+// every transaction has a valid value and fee, a nonce its sender has not
+// confirmed and is covered by the sender's balance before the block, and
+// the block ends with the mining reward plus the fees it collects.
+func (l *ledger) check(b *Block, params *ChainParams) error {
+	n := len(b.transactions)
+	if n == 0 {
+		return ErrInvalidCoinbase
+	}
+	reward := params.MiningReward
+	balances := make(map[string]float64)
+	nonces := make(map[string]map[uint64]bool)
+	for _, t := range b.transactions[:n-1] {
+		if t.senderAddress == params.MiningSender {
+			return ErrInvalidCoinbase
+		}
+		if checkAmounts(t.value, t.fee) != nil {
+			return ErrInvalidBlockAmounts
+		}
+		if nonces[t.senderAddress] == nil {
+			nonces[t.senderAddress] = make(map[uint64]bool)
+		}
+		if t.nonce < l.nextNonces[t.senderAddress] || nonces[t.senderAddress][t.nonce] {
+			return ErrInvalidBlockNonce
+		}
+		nonces[t.senderAddress][t.nonce] = true
+		// Spend from a copy as CopyTransactionPool does, for the same
+		// rounding.
+		balance, ok := balances[t.senderAddress]
+		if !ok {
+			balance = l.balances[t.senderAddress]
+		}
+		if balance < t.value+t.fee {
+			return ErrInvalidBlockFunds
+		}
+		balances[t.senderAddress] = balance - (t.value + t.fee)
+		reward += t.fee
+	}
+	coinbase := b.transactions[n-1]
+	if coinbase.senderAddress != params.MiningSender || coinbase.value != reward || coinbase.fee != 0 || coinbase.nonce != 0 {
+		return ErrInvalidCoinbase
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package block
+
+import (
+	"testing"
+	"time"
+)
+
+// newPeerChain returns another node on bc's network.
+func newPeerChain(bc *Blockchain) *Blockchain {
+	peers := NewPeerManager(PEER_BAN_THRESHOLD, time.Hour)
+	mempool := NewMempool(MEMPOOL_MAX_COUNT, MEMPOOL_MAX_BYTES, MEMPOOL_MAX_PER_SENDER, time.Hour)
+	return NewBlockchain("peer miner", 0, peers, mempool, bc.params)
+}
+
+// testBlock builds on parent a block of transactions followed by a mining
+// reward of reward, with a valid proof of work.
+func testBlock(bc *Blockchain, parent *Block, transactions []*Transaction, reward float64) *Block {
+	transactions = append(transactions, NewTransaction(bc.params.MiningSender, "miner", reward, 0, 0))
+	nonce := bc.ProofOfWork(parent.height+1, parent.Hash(), transactions)
+	return NewBlock(parent.height+1, nonce, parent.Hash(), transactions)
+}
+
+func TestConnectBlockChecksTransactions(t *testing.T) {
+	bc, w := newTestBlockchain(t, 50)
+	sender := w.BlockchainAddress()
+	reward := bc.params.MiningReward
+	genesis := bc.LastBlock()
+
+	for _, c := range []struct {
+		name         string
+		transactions []*Transaction
+		reward       float64
+		err          error
+	}{
+		{"minted reward", []*Transaction{NewTransaction(sender, "bob", 5, 1, 0)}, reward + 1000, ErrInvalidCoinbase},
+		{"missing fees", []*Transaction{NewTransaction(sender, "bob", 5, 1, 0)}, reward, ErrInvalidCoinbase},
+		{"second reward", []*Transaction{NewTransaction(bc.params.MiningSender, "bob", 5, 0, 0)}, reward, ErrInvalidCoinbase},
+		{"overdraft", []*Transaction{NewTransaction(sender, "bob", 60, 0, 0)}, reward, ErrInvalidBlockFunds},
+		{"double spend", []*Transaction{
+			NewTransaction(sender, "bob", 30, 0, 0),
+			NewTransaction(sender, "carol", 30, 0, 1),
+		}, reward, ErrInvalidBlockFunds},
+		{"repeated nonce", []*Transaction{
+			NewTransaction(sender, "bob", 1, 0, 0),
+			NewTransaction(sender, "carol", 1, 0, 0),
+		}, reward, ErrInvalidBlockNonce},
+		{"negative value", []*Transaction{NewTransaction("bob", sender, -40, 0, 0)}, reward, ErrInvalidBlockAmounts},
+	} {
+		if err := bc.ConnectBlock(testBlock(bc, genesis, c.transactions, c.reward)); err != c.err {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.err)
+		}
+	}
+	if h := bc.Height(); h != 0 {
+		t.Fatalf("height is %d after invalid blocks, want 0", h)
+	}
+
+	b := testBlock(bc, genesis, []*Transaction{NewTransaction(sender, "bob", 5, 1, 0)}, reward+1)
+	if err := bc.ConnectBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	reused := testBlock(bc, b, []*Transaction{NewTransaction(sender, "bob", 5, 2, 0)}, reward+2)
+	if err := bc.ConnectBlock(reused); err != ErrInvalidBlockNonce {
+		t.Fatalf("reusing a confirmed nonce: got %v, want %v", err, ErrInvalidBlockNonce)
+	}
+}
+
+func TestConnectBlockAcceptsMinedBlocks(t *testing.T) {
+	bc, w := newTestBlockchain(t, 50)
+	peer := newPeerChain(bc)
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		if err := addSigned(bc, w, 10, 0.3, nonce); err != nil {
+			t.Fatal(err)
+		}
+		if !bc.Mining() {
+			t.Fatal("nothing was mined")
+		}
+		if err := peer.ConnectBlock(bc.LastBlock()); err != nil {
+			t.Fatalf("block %d: %v", nonce+1, err)
+		}
+	}
+}
+
+func TestInvalidBlockIsMisbehaviour(t *testing.T) {
+	bc, _ := newTestBlockchain(t, 50)
+	from := "127.0.0.1:5001"
+	b := testBlock(bc, bc.LastBlock(), nil, bc.params.MiningReward+1000)
+	if _, ok := bc.gossip.receiveBlock(from, b); ok {
+		t.Fatal("a block minting coins was accepted")
+	}
+	if !bc.peers.IsBanned(from) {
+		t.Fatal("the sender of a block minting coins was not banned")
+	}
+}
+
+func TestReplaceChainChecksTransactions(t *testing.T) {
+	bc, w := newTestBlockchain(t, 50)
+	sender := w.BlockchainAddress()
+	reward := bc.params.MiningReward
+	genesis := bc.LastBlock()
+
+	first := testBlock(bc, genesis, []*Transaction{NewTransaction(sender, "bob", 40, 0, 0)}, reward)
+	// Spends what the first block already spent.
+	second := testBlock(bc, first, []*Transaction{NewTransaction(sender, "carol", 40, 0, 1)}, reward)
+	if err := bc.ReplaceChain(1, []*Block{first, second}); err != ErrInvalidBlockFunds {
+		t.Fatalf("got %v, want %v", err, ErrInvalidBlockFunds)
+	}
+	if h := bc.Height(); h != 0 {
+		t.Fatalf("height is %d after an invalid chain, want 0", h)
+	}
+
+	second = testBlock(bc, first, []*Transaction{NewTransaction(sender, "carol", 10, 0, 1)}, reward)
+	if err := bc.ReplaceChain(1, []*Block{first, second}); err != nil {
+		t.Fatal(err)
+	}
+	if balance := bc.CalculateTotalAmount(sender); balance != 0 {
+		t.Fatalf("balance is %f, want 0", balance)
+	}
+}
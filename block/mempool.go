@@ -0,0 +1,313 @@
+package block
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	MEMPOOL_MAX_COUNT      = 5000
+	MEMPOOL_MAX_BYTES      = 5 * 1024 * 1024
+	MEMPOOL_MAX_PER_SENDER = 25
+	MEMPOOL_EXPIRY_SEC     = 60 * 60 * 3
+
+	// A replacement must raise the fee by at least this percentage.
+	MEMPOOL_RBF_MIN_BUMP_PERCENT = 10
+)
+
+// Fee histogram buckets reported by Stats. Bucket i holds fees from
+// MEMPOOL_FEE_BUCKETS[i] up to, but not including, the next boundary.
+var MEMPOOL_FEE_BUCKETS = []float64{0, 0.0001, 0.001, 0.01, 0.1, 1, 10}
+
+var (
+	ErrMempoolDuplicate       = errors.New("transaction is already pending")
+	ErrMempoolSenderLimit     = errors.New("sender has too many pending transactions")
+	ErrMempoolFull            = errors.New("mempool is full and the fee is too low to evict")
+	ErrMempoolTooLarge        = errors.New("transaction is larger than the mempool")
+	ErrReplacementUnderpriced = errors.New("replacement transaction fee is too low")
+)
+
+type mempoolEntry struct {
+	transaction *Transaction
+	size        int
+	added       time.Time
+}
+
+// Mempool holds the pending transactions in arrival order. It is bounded in
+// count and bytes, caps how many transactions a sender may have pending,
+// drops transactions that wait too long and, when full, evicts the lowest
+// fee transactions to make room for better paying ones.
+type Mempool struct {
+	entries      []*mempoolEntry
+	bytes        int
+	maxCount     int
+	maxBytes     int
+	maxPerSender int
+	expiry       time.Duration
+	mux          sync.Mutex
+}
+
+func NewMempool(maxCount int, maxBytes int, maxPerSender int, expiry time.Duration) *Mempool {
+	return &Mempool{
+		entries:      make([]*mempoolEntry, 0),
+		maxCount:     maxCount,
+		maxBytes:     maxBytes,
+		maxPerSender: maxPerSender,
+		expiry:       expiry,
+	}
+}
+
+// transactionSize is the size of a transaction as relayed, signature
+// included.
+func transactionSize(t *Transaction) int {
+	var m []byte
+	if r := t.Request(); r != nil {
+		m, _ = json.Marshal(r)
+	} else {
+		m, _ = json.Marshal(t)
+	}
+	return len(m)
+}
+
+// Add admits t, evicting lower fee transactions if the pool is full. A
+// pending transaction with the same sender and nonce is replaced if t pays
+// a sufficiently higher fee. balance is the sender's confirmed balance, and
+// must cover t on top of the sender's other pending transactions. Checking
+// it under the same lock as admitting t means transactions arriving
+// together cannot spend it twice.
+func (mp *Mempool) Add(t *Transaction, balance float64) error {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	mp.expire(time.Now())
+	hash := t.Hash()
+	fromSender := 0
+	var spend float64
+	var replaced *mempoolEntry
+	for _, e := range mp.entries {
+		if e.transaction.Hash() == hash {
+			return ErrMempoolDuplicate
+		}
+		if e.transaction.senderAddress == t.senderAddress {
+			fromSender++
+			if e.transaction.nonce == t.nonce {
+				replaced = e
+			} else {
+				spend += e.transaction.value + e.transaction.fee
+			}
+		}
+	}
+	if balance-spend < t.value+t.fee {
+		return ErrInsufficientFunds
+	}
+	size := transactionSize(t)
+	if size > mp.maxBytes {
+		return ErrMempoolTooLarge
+	}
+	if replaced != nil {
+		return mp.replace(replaced, t, size)
+	}
+	if fromSender >= mp.maxPerSender {
+		return ErrMempoolSenderLimit
+	}
+
+	// Pick the cheapest transactions to make room, and give up if any of
+	// them pays at least as much as t.
+	candidates := make([]*mempoolEntry, len(mp.entries))
+	copy(candidates, mp.entries)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].transaction.fee < candidates[j].transaction.fee
+	})
+	count, bytes := len(mp.entries)+1, mp.bytes+size
+	evict := make(map[*mempoolEntry]bool)
+	for _, e := range candidates {
+		if count <= mp.maxCount && bytes <= mp.maxBytes {
+			break
+		}
+		if e.transaction.fee >= t.fee {
+			return ErrMempoolFull
+		}
+		evict[e] = true
+		count--
+		bytes -= e.size
+	}
+	if len(evict) > 0 {
+		mp.remove(func(e *mempoolEntry) bool { return evict[e] })
+		log.Printf("action=mempool_evict, count=%d, fee=%f", len(evict), t.fee)
+	}
+
+	mp.entries = append(mp.entries, &mempoolEntry{t, size, time.Now()})
+	mp.bytes += size
+	return nil
+}
+
+// replace swaps e's transaction for t in place, so t keeps its turn.
+func (mp *Mempool) replace(e *mempoolEntry, t *Transaction, size int) error {
+	old := e.transaction.fee
+	if t.fee <= old || t.fee < old*(100+MEMPOOL_RBF_MIN_BUMP_PERCENT)/100 {
+		return ErrReplacementUnderpriced
+	}
+	if mp.bytes+size-e.size > mp.maxBytes {
+		return ErrMempoolFull
+	}
+	mp.bytes += size - e.size
+	e.transaction, e.size, e.added = t, size, time.Now()
+	log.Printf("action=mempool_replace, sender=%s, nonce=%d, fee=%f, old_fee=%f", t.senderAddress, t.nonce, t.fee, old)
+	return nil
+}
+
+// remove drops the entries matching f and returns how many it dropped.
+func (mp *Mempool) remove(f func(e *mempoolEntry) bool) int {
+	kept := make([]*mempoolEntry, 0, len(mp.entries))
+	for _, e := range mp.entries {
+		if f(e) {
+			mp.bytes -= e.size
+			continue
+		}
+		kept = append(kept, e)
+	}
+	removed := len(mp.entries) - len(kept)
+	mp.entries = kept
+	return removed
+}
+
+func (mp *Mempool) expire(now time.Time) {
+	if n := mp.remove(func(e *mempoolEntry) bool { return now.Sub(e.added) > mp.expiry }); n > 0 {
+		log.Printf("action=mempool_expire, count=%d", n)
+	}
+}
+
+// RemoveConfirmed drops the pending transactions included in transactions,
+// and those whose nonce is below their sender's next nonce in nextNonces.
+// The latter were confirmed in another version, such as one paying a
+// different fee.
+func (mp *Mempool) RemoveConfirmed(transactions []*Transaction, nextNonces map[string]uint64) {
+	confirmed := make(map[[32]byte]bool, len(transactions))
+	for _, t := range transactions {
+		confirmed[t.Hash()] = true
+	}
+
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+	mp.remove(func(e *mempoolEntry) bool {
+		if confirmed[e.transaction.Hash()] {
+			return true
+		}
+		next, ok := nextNonces[e.transaction.senderAddress]
+		return ok && e.transaction.nonce < next
+	})
+}
+
+func (mp *Mempool) Clear() {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+	mp.entries = make([]*mempoolEntry, 0)
+	mp.bytes = 0
+}
+
+// Transactions returns the pending transactions in arrival order.
+func (mp *Mempool) Transactions() []*Transaction {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	mp.expire(time.Now())
+	transactions := make([]*Transaction, len(mp.entries))
+	for i, e := range mp.entries {
+		transactions[i] = e.transaction
+	}
+	return transactions
+}
+
+func (mp *Mempool) Get(hash [32]byte) *Transaction {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	for _, e := range mp.entries {
+		if e.transaction.Hash() == hash {
+			return e.transaction
+		}
+	}
+	return nil
+}
+
+// HighestNonce returns the highest nonce pending from sender, and false if
+// the sender has nothing pending.
+func (mp *Mempool) HighestNonce(sender string) (uint64, bool) {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	var highest uint64
+	found := false
+	for _, e := range mp.entries {
+		if e.transaction.senderAddress == sender && (!found || e.transaction.nonce > highest) {
+			highest = e.transaction.nonce
+			found = true
+		}
+	}
+	return highest, found
+}
+
+func (mp *Mempool) Len() int {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+	return len(mp.entries)
+}
+
+type FeeBucket struct {
+	MinFee float64  `json:"min_fee"`
+	MaxFee *float64 `json:"max_fee,omitempty"`
+	Count  int      `json:"count"`
+	Bytes  int      `json:"bytes"`
+}
+
+type MempoolStats struct {
+	Size         int          `json:"size"`
+	Bytes        int          `json:"bytes"`
+	MaxSize      int          `json:"max_size"`
+	MaxBytes     int          `json:"max_bytes"`
+	MaxPerSender int          `json:"max_per_sender"`
+	ExpirySec    int          `json:"expiry_sec"`
+	TotalFees    float64      `json:"total_fees"`
+	FeeHistogram []*FeeBucket `json:"fee_histogram"`
+}
+
+func (mp *Mempool) Stats() *MempoolStats {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	mp.expire(time.Now())
+	histogram := make([]*FeeBucket, len(MEMPOOL_FEE_BUCKETS))
+	for i, min := range MEMPOOL_FEE_BUCKETS {
+		histogram[i] = &FeeBucket{MinFee: min}
+		if i+1 < len(MEMPOOL_FEE_BUCKETS) {
+			max := MEMPOOL_FEE_BUCKETS[i+1]
+			histogram[i].MaxFee = &max
+		}
+	}
+	var totalFees float64
+	for _, e := range mp.entries {
+		i := sort.Search(len(MEMPOOL_FEE_BUCKETS), func(i int) bool {
+			return MEMPOOL_FEE_BUCKETS[i] > e.transaction.fee
+		}) - 1
+		if i < 0 {
+			i = 0
+		}
+		histogram[i].Count++
+		histogram[i].Bytes += e.size
+		totalFees += e.transaction.fee
+	}
+	return &MempoolStats{
+		Size:         len(mp.entries),
+		Bytes:        mp.bytes,
+		MaxSize:      mp.maxCount,
+		MaxBytes:     mp.maxBytes,
+		MaxPerSender: mp.maxPerSender,
+		ExpirySec:    int(mp.expiry / time.Second),
+		TotalFees:    totalFees,
+		FeeHistogram: histogram,
+	}
+}
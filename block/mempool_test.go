@@ -0,0 +1,169 @@
+package block
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+const testBalance = 1000
+
+func pendingFees(mp *Mempool) []float64 {
+	fees := make([]float64, 0)
+	for _, t := range mp.Transactions() {
+		fees = append(fees, t.fee)
+	}
+	return fees
+}
+
+func TestMempoolEvictsLowestFee(t *testing.T) {
+	mp := NewMempool(3, MEMPOOL_MAX_BYTES, MEMPOOL_MAX_PER_SENDER, time.Hour)
+	for i, fee := range []float64{0.3, 0.1, 0.2} {
+		if err := mp.Add(NewTransaction(fmt.Sprintf("sender%d", i), "bob", 1, fee, 0), testBalance); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mp.Add(NewTransaction("sender3", "bob", 1, 0.15, 0), testBalance); err != nil {
+		t.Fatal(err)
+	}
+	if fees := fmt.Sprint(pendingFees(mp)); fees != "[0.3 0.2 0.15]" {
+		t.Fatalf("pending fees are %s, want the 0.1 fee evicted", fees)
+	}
+	if err := mp.Add(NewTransaction("sender4", "bob", 1, 0.15, 0), testBalance); err != ErrMempoolFull {
+		t.Fatalf("got %v, want %v for a fee no higher than the lowest", err, ErrMempoolFull)
+	}
+}
+
+func TestMempoolByteCap(t *testing.T) {
+	size := transactionSize(NewTransaction("sender0", "bob", 1, 0.1, 0))
+	mp := NewMempool(MEMPOOL_MAX_COUNT, 2*size, MEMPOOL_MAX_PER_SENDER, time.Hour)
+	for i, fee := range []float64{0.2, 0.1} {
+		if err := mp.Add(NewTransaction(fmt.Sprintf("sender%d", i), "bob", 1, fee, 0), testBalance); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mp.Add(NewTransaction("sender2", "bob", 1, 0.3, 0), testBalance); err != nil {
+		t.Fatal(err)
+	}
+	if fees := fmt.Sprint(pendingFees(mp)); fees != "[0.2 0.3]" {
+		t.Fatalf("pending fees are %s, want the 0.1 fee evicted", fees)
+	}
+	if stats := mp.Stats(); stats.Bytes > stats.MaxBytes {
+		t.Fatalf("mempool holds %d bytes, more than its %d", stats.Bytes, stats.MaxBytes)
+	}
+
+	huge := NewTransaction(string(make([]byte, 2*size)), "bob", 1, 10, 0)
+	if err := mp.Add(huge, testBalance); err != ErrMempoolTooLarge {
+		t.Fatalf("got %v, want %v", err, ErrMempoolTooLarge)
+	}
+}
+
+func TestMempoolSenderLimit(t *testing.T) {
+	mp := NewMempool(MEMPOOL_MAX_COUNT, MEMPOOL_MAX_BYTES, 2, time.Hour)
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		if err := mp.Add(NewTransaction("alice", "bob", 1, 0.1, nonce), testBalance); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mp.Add(NewTransaction("alice", "bob", 1, 0.1, 2), testBalance); err != ErrMempoolSenderLimit {
+		t.Fatalf("got %v, want %v", err, ErrMempoolSenderLimit)
+	}
+	if err := mp.Add(NewTransaction("carol", "bob", 1, 0.1, 0), testBalance); err != nil {
+		t.Fatalf("another sender was refused: %v", err)
+	}
+}
+
+func TestMempoolExpiry(t *testing.T) {
+	expiry := time.Minute
+	mp := NewMempool(MEMPOOL_MAX_COUNT, MEMPOOL_MAX_BYTES, MEMPOOL_MAX_PER_SENDER, expiry)
+	for i := 0; i < 2; i++ {
+		if err := mp.Add(NewTransaction(fmt.Sprintf("sender%d", i), "bob", 1, 0.1, 0), testBalance); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mp.entries[0].added = time.Now().Add(-expiry - time.Second)
+
+	transactions := mp.Transactions()
+	if len(transactions) != 1 || transactions[0].senderAddress != "sender1" {
+		t.Fatalf("pending after expiry: %v, want only sender1", transactions)
+	}
+	if stats := mp.Stats(); stats.Bytes != transactionSize(transactions[0]) {
+		t.Fatalf("mempool counts %d bytes, want those of the one left", stats.Bytes)
+	}
+}
+
+func TestMempoolReplaceByFee(t *testing.T) {
+	mp := NewMempool(MEMPOOL_MAX_COUNT, MEMPOOL_MAX_BYTES, MEMPOOL_MAX_PER_SENDER, time.Hour)
+	original := NewTransaction("alice", "bob", 1, 1, 0)
+	if err := mp.Add(original, testBalance); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Add(NewTransaction("carol", "bob", 1, 1, 0), testBalance); err != nil {
+		t.Fatal(err)
+	}
+
+	// A bump below MEMPOOL_RBF_MIN_BUMP_PERCENT is refused.
+	for _, fee := range []float64{0.5, 1, 1.09} {
+		if err := mp.Add(NewTransaction("alice", "dave", 1, fee, 0), testBalance); err != ErrReplacementUnderpriced {
+			t.Errorf("fee %v: got %v, want %v", fee, err, ErrReplacementUnderpriced)
+		}
+	}
+
+	replacement := NewTransaction("alice", "dave", 1, 1.1, 0)
+	if err := mp.Add(replacement, testBalance); err != nil {
+		t.Fatal(err)
+	}
+	transactions := mp.Transactions()
+	if len(transactions) != 2 || transactions[0] != replacement {
+		t.Fatalf("pending %v, want the replacement in the original's place", transactions)
+	}
+	if mp.Get(original.Hash()) != nil {
+		t.Fatal("the original is still pending")
+	}
+	if stats := mp.Stats(); stats.Bytes != transactionSize(replacement)+transactionSize(transactions[1]) {
+		t.Fatalf("mempool counts %d bytes after the replacement", stats.Bytes)
+	}
+}
+
+func TestMempoolReplacementBalance(t *testing.T) {
+	mp := NewMempool(MEMPOOL_MAX_COUNT, MEMPOOL_MAX_BYTES, MEMPOOL_MAX_PER_SENDER, time.Hour)
+	if err := mp.Add(NewTransaction("alice", "bob", 5, 1, 0), 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Add(NewTransaction("alice", "bob", 3, 1, 1), 10); err != nil {
+		t.Fatal(err)
+	}
+	// The replaced transaction's spend no longer counts, the other's does.
+	if err := mp.Add(NewTransaction("alice", "bob", 5, 2, 0), 10); err != ErrInsufficientFunds {
+		t.Fatalf("got %v, want %v", err, ErrInsufficientFunds)
+	}
+	if err := mp.Add(NewTransaction("alice", "bob", 4, 2, 0), 10); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMempoolReplacementByteCap(t *testing.T) {
+	small := NewTransaction("alice", "bob", 1, 1, 0)
+	other := NewTransaction("carol", "bob", 1, 1, 0)
+	mp := NewMempool(MEMPOOL_MAX_COUNT, transactionSize(small)+transactionSize(other), MEMPOOL_MAX_PER_SENDER, time.Hour)
+	if err := mp.Add(small, testBalance); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Add(other, testBalance); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same nonce with a higher fee and a longer receiver is larger.
+	larger := NewTransaction("alice", "a much longer receiver address", 1, 2, 0)
+	if err := mp.Add(larger, testBalance); err != ErrMempoolFull {
+		t.Fatalf("got %v, want %v", err, ErrMempoolFull)
+	}
+	if mp.Get(small.Hash()) == nil {
+		t.Fatal("the original was dropped by a refused replacement")
+	}
+	if stats := mp.Stats(); stats.Bytes > stats.MaxBytes {
+		t.Fatalf("mempool holds %d bytes, more than its %d", stats.Bytes, stats.MaxBytes)
+	}
+}
@@ -0,0 +1,136 @@
+package block
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+)
+
+// ChainParams holds the consensus rules and defaults of a network. Nodes on
+// different networks never share a genesis block, so their chains cannot be
+// mixed up.
+type ChainParams struct {
+	Name           string
+	ChainID        uint32
+	AddressVersion byte
+
+	MiningDifficulty int
+	MiningSender     string
+	MiningReward     float64
+	MiningTimerSec   int
+
+	DefaultPort           uint16
+	PortRangeStart        uint16
+	PortRangeEnd          uint16
+	NeighbourIPRangeStart uint8
+	NeighbourIPRangeEnd   uint8
+	NeighbourSyncTimeSec  int
+
+	GenesisTimestamp   int64
+	GenesisExtraData   []byte
+	GenesisAllocations map[string]float64
+}
+
+var MainnetParams = &ChainParams{
+	Name:           "mainnet",
+	ChainID:        1,
+	AddressVersion: 0x00,
+
+	MiningDifficulty: 3,
+	MiningSender:     "THE BLOCKCHAIN",
+	MiningReward:     1.000000000000000000,
+	MiningTimerSec:   20,
+
+	DefaultPort:           5000,
+	PortRangeStart:        5000,
+	PortRangeEnd:          5003,
+	NeighbourIPRangeStart: 0,
+	NeighbourIPRangeEnd:   1,
+	NeighbourSyncTimeSec:  20,
+
+	GenesisTimestamp: 1698796800000000000,
+}
+
+var TestnetParams = &ChainParams{
+	Name:           "testnet",
+	ChainID:        2,
+	AddressVersion: 0x6f,
+
+	MiningDifficulty: 2,
+	MiningSender:     "THE TESTNET BLOCKCHAIN",
+	MiningReward:     10.000000000000000000,
+	MiningTimerSec:   10,
+
+	DefaultPort:           7000,
+	PortRangeStart:        7000,
+	PortRangeEnd:          7003,
+	NeighbourIPRangeStart: 0,
+	NeighbourIPRangeEnd:   1,
+	NeighbourSyncTimeSec:  20,
+
+	GenesisTimestamp: 1698796800000000000,
+}
+
+// DevnetParams is meant for a single machine or CI: blocks are cheap and
+// frequent, and neighbours are only looked for on the local host.
+var DevnetParams = &ChainParams{
+	Name:           "devnet",
+	ChainID:        1337,
+	AddressVersion: 0x70,
+
+	MiningDifficulty: 1,
+	MiningSender:     "THE DEVNET BLOCKCHAIN",
+	MiningReward:     100.000000000000000000,
+	MiningTimerSec:   5,
+
+	DefaultPort:           9000,
+	PortRangeStart:        9000,
+	PortRangeEnd:          9003,
+	NeighbourIPRangeStart: 0,
+	NeighbourIPRangeEnd:   0,
+	NeighbourSyncTimeSec:  5,
+
+	GenesisTimestamp: 1698796800000000000,
+}
+
+var networks = map[string]*ChainParams{
+	MainnetParams.Name: MainnetParams,
+	TestnetParams.Name: TestnetParams,
+	DevnetParams.Name:  DevnetParams,
+}
+
+func ParamsForNetwork(name string) (*ChainParams, error) {
+	params, ok := networks[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q, expected one of %v", name, NetworkNames())
+	}
+	return params, nil
+}
+
+func NetworkNames() []string {
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Genesis builds the network's first block. Its previous hash commits to the
+// chain ID so every network starts from a different block, and to the mining
+// difficulty so nodes sharing a genesis block agree on the proof of work.
+// Premined balances are paid out by the mining sender in address order.
+func (p *ChainParams) Genesis() *Block {
+	previousHash := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d", p.Name, p.ChainID, p.MiningDifficulty)))
+
+	addresses := make([]string, 0, len(p.GenesisAllocations))
+	for address := range p.GenesisAllocations {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	transactions := make([]*Transaction, 0, len(addresses))
+	for _, address := range addresses {
+		transactions = append(transactions, NewTransaction(p.MiningSender, address, p.GenesisAllocations[address], 0, 0))
+	}
+	return &Block{p.GenesisTimestamp, 0, previousHash, transactions, p.GenesisExtraData, 0}
+}
@@ -0,0 +1,308 @@
+package block
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/palmcivet7/go-blockchain/utils"
+)
+
+const (
+	PEER_BAN_THRESHOLD    = 100
+	PEER_BAN_DURATION_SEC = 60 * 60 * 24
+
+	// Neighbours name the address we dial them on with this header. It is
+	// only believed for a neighbour on the IP the request came from.
+	NODE_ADDRESS_HEADER = "X-Node-Address"
+	// Node-to-node requests from another network are refused.
+	NODE_CHAIN_ID_HEADER = "X-Chain-Id"
+)
+
+type Misbehaviour int
+
+const (
+	MisbehaviourInvalidSignature Misbehaviour = iota
+	MisbehaviourBadProofOfWork
+	MisbehaviourMalformedJSON
+	MisbehaviourTimeout
+	MisbehaviourImplausibleHeight
+	MisbehaviourUnexpectedMessage
+	MisbehaviourInvalidBlock
+)
+
+var misbehaviourPenalties = map[Misbehaviour]int{
+	MisbehaviourInvalidSignature:  50,
+	MisbehaviourBadProofOfWork:    100,
+	MisbehaviourMalformedJSON:     20,
+	MisbehaviourTimeout:           10,
+	MisbehaviourImplausibleHeight: 50,
+	MisbehaviourUnexpectedMessage: 20,
+	MisbehaviourInvalidBlock:      100,
+}
+
+func (m Misbehaviour) String() string {
+	switch m {
+	case MisbehaviourInvalidSignature:
+		return "invalid_signature"
+	case MisbehaviourBadProofOfWork:
+		return "bad_proof_of_work"
+	case MisbehaviourMalformedJSON:
+		return "malformed_json"
+	case MisbehaviourTimeout:
+		return "timeout"
+	case MisbehaviourImplausibleHeight:
+		return "implausible_height"
+	case MisbehaviourUnexpectedMessage:
+		return "unexpected_message"
+	case MisbehaviourInvalidBlock:
+		return "invalid_block"
+	}
+	return "unknown"
+}
+
+type Peer struct {
+	address       string
+	score         int
+	misbehaviours map[Misbehaviour]int
+	lastSeen      time.Time
+	bannedUntil   time.Time
+	// nodeID is the identity of the TLS certificate the peer presented when
+	// we first dialed it, or the one it was pinned to.
+	nodeID string
+	pinned bool
+}
+
+func (p *Peer) IsBanned(now time.Time) bool {
+	return now.Before(p.bannedUntil)
+}
+
+func (p *Peer) MarshalJSON() ([]byte, error) {
+	misbehaviours := make(map[string]int)
+	for m, count := range p.misbehaviours {
+		misbehaviours[m.String()] = count
+	}
+	var bannedUntil int64
+	if p.IsBanned(time.Now()) {
+		bannedUntil = p.bannedUntil.Unix()
+	}
+	var lastSeen int64
+	if !p.lastSeen.IsZero() {
+		lastSeen = p.lastSeen.Unix()
+	}
+	return json.Marshal(struct {
+		Address       string         `json:"address"`
+		Score         int            `json:"score"`
+		Misbehaviours map[string]int `json:"misbehaviours"`
+		LastSeen      int64          `json:"last_seen"`
+		Banned        bool           `json:"banned"`
+		BannedUntil   int64          `json:"banned_until"`
+		NodeID        string         `json:"node_id,omitempty"`
+	}{
+		Address:       p.address,
+		Score:         p.score,
+		Misbehaviours: misbehaviours,
+		LastSeen:      lastSeen,
+		Banned:        bannedUntil != 0,
+		BannedUntil:   bannedUntil,
+		NodeID:        p.nodeID,
+	})
+}
+
+type PeerManager struct {
+	peers        map[string]*Peer
+	banThreshold int
+	banDuration  time.Duration
+	mux          sync.Mutex
+}
+
+func NewPeerManager(banThreshold int, banDuration time.Duration) *PeerManager {
+	return &PeerManager{
+		peers:        make(map[string]*Peer),
+		banThreshold: banThreshold,
+		banDuration:  banDuration,
+	}
+}
+
+func (pm *PeerManager) peer(address string) *Peer {
+	p, ok := pm.peers[address]
+	if !ok {
+		p = &Peer{address: address, misbehaviours: make(map[Misbehaviour]int)}
+		pm.peers[address] = p
+	}
+	return p
+}
+
+func (pm *PeerManager) Seen(address string) {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+	pm.peer(address).lastSeen = time.Now()
+}
+
+// Misbehaving adds the penalty for m to the peer's score and bans the peer
+// once the score reaches the threshold. It reports whether the peer is banned.
+func (pm *PeerManager) Misbehaving(address string, m Misbehaviour) bool {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+
+	now := time.Now()
+	p := pm.peer(address)
+	if p.IsBanned(now) {
+		return true
+	}
+	p.score += misbehaviourPenalties[m]
+	p.misbehaviours[m]++
+	log.Printf("action=misbehaving, peer=%s, reason=%s, score=%d", address, m, p.score)
+
+	if p.score >= pm.banThreshold {
+		p.bannedUntil = now.Add(pm.banDuration)
+		log.Printf("action=ban, peer=%s, until=%s", address, p.bannedUntil.Format(time.RFC3339))
+		return true
+	}
+	return false
+}
+
+// IsBanned reports whether address, or the host it is on, is banned.
+// Inbound requests are scored by IP, so a ban on an IP covers every node
+// on it.
+func (pm *PeerManager) IsBanned(address string) bool {
+	if host, _, err := net.SplitHostPort(address); err == nil && pm.isBanned(host) {
+		return true
+	}
+	return pm.isBanned(address)
+}
+
+func (pm *PeerManager) isBanned(address string) bool {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+
+	p, ok := pm.peers[address]
+	if !ok {
+		return false
+	}
+	now := time.Now()
+	if p.IsBanned(now) {
+		return true
+	}
+	if !p.bannedUntil.IsZero() {
+		// The ban has expired, give the peer a clean slate.
+		p.bannedUntil = time.Time{}
+		p.score = 0
+	}
+	return false
+}
+
+// Forgive lifts any ban on the peer and clears its score. It reports
+// whether the peer was known.
+func (pm *PeerManager) Forgive(address string) bool {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+
+	p, ok := pm.peers[address]
+	if !ok {
+		return false
+	}
+	p.score = 0
+	p.misbehaviours = make(map[Misbehaviour]int)
+	p.bannedUntil = time.Time{}
+	if !p.pinned {
+		p.nodeID = ""
+	}
+	log.Printf("action=forgive, peer=%s", address)
+	return true
+}
+
+func peerNodeID(state *tls.ConnectionState) string {
+	if len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	id, err := utils.CertificateNodeID(state.PeerCertificates[0].Raw)
+	if err != nil {
+		return ""
+	}
+	return id
+}
+
+// PinIdentity requires the node at address to present nodeID, rather than
+// trusting whichever node answers first.
+func (pm *PeerManager) PinIdentity(address string, nodeID string) {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+	p := pm.peer(address)
+	p.nodeID = nodeID
+	p.pinned = true
+}
+
+// BindIdentity ties the node ID of the certificate in state to address the
+// first time we dial it, unless it was pinned, and afterwards reports
+// whether it is still the same node. Connections without TLS have nothing
+// to check.
+func (pm *PeerManager) BindIdentity(address string, state *tls.ConnectionState) bool {
+	if state == nil {
+		return true
+	}
+	id := peerNodeID(state)
+	if id == "" {
+		return false
+	}
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+	p := pm.peer(address)
+	if p.nodeID == "" {
+		p.nodeID = id
+		log.Printf("action=bind_identity, peer=%s, node_id=%s", address, id)
+		return true
+	}
+	if p.nodeID != id {
+		log.Printf("ERROR: %s presented node %s, expected %s", address, id, p.nodeID)
+		return false
+	}
+	return true
+}
+
+// MatchesIdentity reports whether a client claiming to be address
+// presented the certificate bound to it. Only dialing binds an identity, so
+// a client cannot claim an address first.
+func (pm *PeerManager) MatchesIdentity(address string, state *tls.ConnectionState) bool {
+	if state == nil {
+		return true
+	}
+	id := peerNodeID(state)
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+	p, ok := pm.peers[address]
+	return ok && id != "" && p.nodeID == id
+}
+
+// Filter returns the addresses that are not currently banned.
+func (pm *PeerManager) Filter(addresses []string) []string {
+	filtered := make([]string, 0, len(addresses))
+	for _, a := range addresses {
+		if !pm.IsBanned(a) {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered
+}
+
+func (pm *PeerManager) Peers() []*Peer {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+
+	peers := make([]*Peer, 0, len(pm.peers))
+	for _, p := range pm.peers {
+		misbehaviours := make(map[Misbehaviour]int, len(p.misbehaviours))
+		for m, count := range p.misbehaviours {
+			misbehaviours[m] = count
+		}
+		peers = append(peers, &Peer{p.address, p.score, misbehaviours, p.lastSeen, p.bannedUntil, p.nodeID, p.pinned})
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].address < peers[j].address
+	})
+	return peers
+}
@@ -0,0 +1,152 @@
+package block
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/palmcivet7/go-blockchain/utils"
+)
+
+func TestMisbehavingBansAtThreshold(t *testing.T) {
+	pm := NewPeerManager(PEER_BAN_THRESHOLD, time.Hour)
+	peer := "127.0.0.1:5001"
+	// 50 + 20 + 20 stays under the threshold of 100.
+	for _, m := range []Misbehaviour{MisbehaviourInvalidSignature, MisbehaviourMalformedJSON, MisbehaviourMalformedJSON} {
+		if pm.Misbehaving(peer, m) {
+			t.Fatalf("banned after %s", m)
+		}
+	}
+	if pm.IsBanned(peer) {
+		t.Fatal("banned below the threshold")
+	}
+	if !pm.Misbehaving(peer, MisbehaviourTimeout) || !pm.IsBanned(peer) {
+		t.Fatal("not banned at the threshold")
+	}
+
+	// A banned peer's score is not raised further.
+	pm.Misbehaving(peer, MisbehaviourBadProofOfWork)
+	p := pm.Peers()[0]
+	if p.score != PEER_BAN_THRESHOLD || p.misbehaviours[MisbehaviourMalformedJSON] != 2 || p.misbehaviours[MisbehaviourBadProofOfWork] != 0 {
+		t.Fatalf("score %d, misbehaviours %v", p.score, p.misbehaviours)
+	}
+}
+
+func TestBanExpires(t *testing.T) {
+	banDuration := 50 * time.Millisecond
+	pm := NewPeerManager(PEER_BAN_THRESHOLD, banDuration)
+	peer := "127.0.0.1:5001"
+	pm.Misbehaving(peer, MisbehaviourBadProofOfWork)
+	if !pm.IsBanned(peer) {
+		t.Fatal("not banned")
+	}
+
+	time.Sleep(2 * banDuration)
+	if pm.IsBanned(peer) {
+		t.Fatal("still banned after the ban expired")
+	}
+	// The expired ban left a clean slate, so one small offence does not ban
+	// the peer again.
+	if pm.Misbehaving(peer, MisbehaviourTimeout) {
+		t.Fatal("banned again by the score of the expired ban")
+	}
+}
+
+func TestHostBanCoversEveryPort(t *testing.T) {
+	pm := NewPeerManager(PEER_BAN_THRESHOLD, time.Hour)
+	pm.Misbehaving("192.0.2.1", MisbehaviourBadProofOfWork)
+	for _, address := range []string{"192.0.2.1", "192.0.2.1:5000", "192.0.2.1:5001"} {
+		if !pm.IsBanned(address) {
+			t.Errorf("%s is not banned with its host", address)
+		}
+	}
+
+	pm.Misbehaving("192.0.2.2:5000", MisbehaviourBadProofOfWork)
+	if pm.IsBanned("192.0.2.2") || pm.IsBanned("192.0.2.2:5001") {
+		t.Error("a ban on one node covers its host")
+	}
+	if got := pm.Filter([]string{"192.0.2.1:5000", "192.0.2.2:5000", "192.0.2.2:5001"}); len(got) != 1 || got[0] != "192.0.2.2:5001" {
+		t.Errorf("filtered %v", got)
+	}
+}
+
+func TestForgive(t *testing.T) {
+	pm := NewPeerManager(PEER_BAN_THRESHOLD, time.Hour)
+	peer := "127.0.0.1:5001"
+	if pm.Forgive(peer) {
+		t.Fatal("forgave an unknown peer")
+	}
+	pm.Misbehaving(peer, MisbehaviourBadProofOfWork)
+	if !pm.Forgive(peer) {
+		t.Fatal("did not forgive a known peer")
+	}
+	if pm.IsBanned(peer) {
+		t.Fatal("still banned after being forgiven")
+	}
+	p := pm.Peers()[0]
+	if p.score != 0 || len(p.misbehaviours) != 0 {
+		t.Fatalf("score %d, misbehaviours %v after being forgiven", p.score, p.misbehaviours)
+	}
+}
+
+// nodeConnection is what dialing a node with a new identity shows, and
+// that identity's node ID.
+func nodeConnection(t *testing.T) (*tls.ConnectionState, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cert, err := utils.LoadOrCreateNodeIdentity(filepath.Join(dir, "node.key"), filepath.Join(dir, "node.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := utils.CertificateNodeID(c.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{c}}, id
+}
+
+func TestBindIdentityOnFirstContact(t *testing.T) {
+	pm := NewPeerManager(PEER_BAN_THRESHOLD, time.Hour)
+	peer := "127.0.0.1:5001"
+	first, _ := nodeConnection(t)
+	other, _ := nodeConnection(t)
+	if pm.MatchesIdentity(peer, first) {
+		t.Fatal("a client matched an address before it was dialed")
+	}
+	if !pm.BindIdentity(peer, first) || !pm.BindIdentity(peer, first) || !pm.MatchesIdentity(peer, first) {
+		t.Fatal("the first certificate was not bound")
+	}
+	if pm.BindIdentity(peer, other) || pm.MatchesIdentity(peer, other) {
+		t.Fatal("another certificate was accepted after the first was bound")
+	}
+	if !pm.BindIdentity(peer, nil) {
+		t.Fatal("a connection without TLS was refused")
+	}
+}
+
+func TestPinnedIdentity(t *testing.T) {
+	pm := NewPeerManager(PEER_BAN_THRESHOLD, time.Hour)
+	peer := "127.0.0.1:5001"
+	pinned, id := nodeConnection(t)
+	impostor, _ := nodeConnection(t)
+	pm.PinIdentity(peer, id)
+
+	// The impostor answering first is not trusted on first contact.
+	if pm.BindIdentity(peer, impostor) || pm.MatchesIdentity(peer, impostor) {
+		t.Fatal("a certificate other than the pinned one was accepted")
+	}
+	if !pm.BindIdentity(peer, pinned) || !pm.MatchesIdentity(peer, pinned) {
+		t.Fatal("the pinned certificate was refused")
+	}
+	// Forgiving a peer forgets a bound identity, not a pinned one.
+	pm.Forgive(peer)
+	if pm.BindIdentity(peer, impostor) {
+		t.Fatal("a certificate other than the pinned one was accepted after forgiving")
+	}
+}
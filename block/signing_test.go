@@ -0,0 +1,51 @@
+package block
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/palmcivet7/go-blockchain/utils"
+	"github.com/palmcivet7/go-blockchain/wallet"
+)
+
+// The node must verify exactly what the wallet signs, over the encoding in
+// utils.TransactionSigningBytes.
+func TestWalletSignatureVerifiesOnNode(t *testing.T) {
+	bc, w := newTestBlockchain(t, 10)
+	chainID := bc.params.ChainID
+	s := wallet.NewTransaction(w.PrivateKey(), w.PublicKey(), w.BlockchainAddress(), "bob", 1.5, 0.25, 7).GenerateSignature(chainID)
+
+	// Relayed signatures are parsed, which only accepts low S.
+	parsed, err := utils.SignatureFromString(s.String())
+	if err != nil {
+		t.Fatalf("wallet signature rejected: %v", err)
+	}
+	tx := NewTransaction(w.BlockchainAddress(), "bob", 1.5, 0.25, 7)
+	if !bc.VerifyTransactionSignature(w.PublicKey(), parsed, tx) {
+		t.Fatal("node rejected the wallet's signature")
+	}
+	if tx.SigningHash(chainID) != utils.TransactionSigningHash(chainID, w.BlockchainAddress(), "bob", 1.5, 0.25, 7) {
+		t.Fatal("node and utils signing hashes differ")
+	}
+
+	other := NewTransaction(w.BlockchainAddress(), "bob", 1.5, 0.25, 8)
+	if bc.VerifyTransactionSignature(w.PublicKey(), parsed, other) {
+		t.Fatal("signature verified for a different nonce")
+	}
+}
+
+func TestSigningHashGolden(t *testing.T) {
+	tx := NewTransaction("alice", "bob", 1.5, 0.25, 7)
+	h := tx.SigningHash(1337)
+	if got := hex.EncodeToString(h[:]); got != "6276e7222b679c223040a518bdbbeb7b48c99006cdfa4ff57280a3cd95d915f3" {
+		t.Fatalf("signing hash %s does not match the utils golden vector", got)
+	}
+}
+
+func TestSignatureBoundToChain(t *testing.T) {
+	bc, w := newTestBlockchain(t, 10)
+	s := wallet.NewTransaction(w.PrivateKey(), w.PublicKey(), w.BlockchainAddress(), "bob", 1, 0, 0).GenerateSignature(MainnetParams.ChainID)
+	if bc.VerifyTransactionSignature(w.PublicKey(), s, NewTransaction(w.BlockchainAddress(), "bob", 1, 0, 0)) {
+		t.Fatal("a mainnet signature verified on devnet")
+	}
+}
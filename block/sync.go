@@ -0,0 +1,364 @@
+package block
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	SYNC_HEADERS_BATCH   = 2000
+	SYNC_BLOCKS_BATCH    = 100
+	SYNC_BLOCKS_WORKERS  = 4
+	SYNC_BLOCKS_ATTEMPTS = 3
+	// A neighbour claiming to be further ahead of our tip than this is
+	// lying, and is not synced from.
+	SYNC_MAX_HEIGHT_AHEAD = 1 << 20
+)
+
+const (
+	SyncStateIdle    = "idle"
+	SyncStateHeaders = "headers"
+	SyncStateBlocks  = "blocks"
+	SyncStateDone    = "done"
+	SyncStateFailed  = "failed"
+)
+
+type HeadersResponse struct {
+	Headers []*BlockHeader `json:"headers"`
+	Height  int            `json:"height"`
+}
+
+type BlocksResponse struct {
+	Blocks []*Block `json:"blocks"`
+}
+
+type SyncStatus struct {
+	State        string `json:"state"`
+	Peer         string `json:"peer"`
+	LocalHeight  int    `json:"local_height"`
+	TargetHeight int    `json:"target_height"`
+	Headers      int    `json:"headers"`
+	Blocks       int    `json:"blocks"`
+	BlocksTotal  int    `json:"blocks_total"`
+	StartedAt    int64  `json:"started_at"`
+	FinishedAt   int64  `json:"finished_at"`
+	Error        string `json:"error,omitempty"`
+}
+
+// Syncer performs the initial block download: it fetches and validates the
+// header chain of the best neighbour first, then downloads the bodies in
+// ranges from every neighbour that has them.
+type Syncer struct {
+	bc     *Blockchain
+	status SyncStatus
+	// heights holds the height each neighbour last reported.
+	heights map[string]int
+	mux     sync.Mutex
+}
+
+func NewSyncer(bc *Blockchain) *Syncer {
+	return &Syncer{bc: bc, status: SyncStatus{State: SyncStateIdle}, heights: make(map[string]int)}
+}
+
+// observe records the height peer reported, unless it is implausible.
+func (s *Syncer) observe(peer string, height int) {
+	if height < 0 || height > s.bc.Height()+SYNC_MAX_HEIGHT_AHEAD {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.heights[peer] = height
+}
+
+// BestPeerHeight returns the highest height reported by a neighbour that is
+// not banned, and -1 if none has reported one. Neighbours report it when
+// synced from, when connecting over the wire protocol and when polled by
+// SyncNeighbours.
+func (s *Syncer) BestPeerHeight() int {
+	neighbours := make(map[string]bool)
+	for _, n := range s.bc.Neighbours() {
+		neighbours[n] = true
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	best := -1
+	for peer, height := range s.heights {
+		if !neighbours[peer] {
+			delete(s.heights, peer)
+			continue
+		}
+		if height > best && !s.bc.peers.IsBanned(peer) {
+			best = height
+		}
+	}
+	return best
+}
+
+func (s *Syncer) Status() SyncStatus {
+	height := s.bc.Height()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	status := s.status
+	status.LocalHeight = height
+	return status
+}
+
+func (s *Syncer) IsSyncing() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.status.State == SyncStateHeaders || s.status.State == SyncStateBlocks
+}
+
+func (s *Syncer) update(f func(status *SyncStatus)) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	f(&s.status)
+}
+
+func (s *Syncer) fail(err error) {
+	log.Printf("ERROR: sync: %v", err)
+	s.update(func(status *SyncStatus) {
+		status.State = SyncStateFailed
+		status.Error = err.Error()
+		status.FinishedAt = time.Now().Unix()
+	})
+}
+
+func (s *Syncer) Run() {
+	s.mux.Lock()
+	if s.status.State == SyncStateHeaders || s.status.State == SyncStateBlocks {
+		s.mux.Unlock()
+		return
+	}
+	s.status = SyncStatus{State: SyncStateHeaders, StartedAt: time.Now().Unix()}
+	s.mux.Unlock()
+
+	neighbours := s.bc.Neighbours()
+	peer, height := s.bestPeer(neighbours)
+	if peer == "" || height <= s.bc.Height() {
+		s.update(func(status *SyncStatus) {
+			status.State = SyncStateDone
+			status.TargetHeight = s.bc.Height()
+			status.FinishedAt = time.Now().Unix()
+		})
+		log.Println("action=sync, status=up_to_date")
+		return
+	}
+	s.update(func(status *SyncStatus) {
+		status.Peer = peer
+		status.TargetHeight = height
+	})
+
+	headers, err := s.downloadHeaders(peer, height)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+
+	from := s.forkPoint(headers)
+	s.update(func(status *SyncStatus) {
+		status.State = SyncStateBlocks
+		status.BlocksTotal = len(headers) - from
+	})
+
+	blocks, err := s.downloadBlocks(neighbours, headers, from)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	if err := s.bc.ReplaceChain(from, blocks); err != nil {
+		// The bodies match the headers peer sent, so it vouched for them.
+		if IsInvalidBlock(err) || err == ErrBadProofOfWork {
+			s.bc.peers.Misbehaving(peer, MisbehaviourInvalidBlock)
+		}
+		s.fail(fmt.Errorf("downloaded chain was rejected: %w", err))
+		return
+	}
+	s.update(func(status *SyncStatus) {
+		status.State = SyncStateDone
+		status.FinishedAt = time.Now().Unix()
+	})
+	log.Printf("action=sync, status=success, height=%d", s.bc.Height())
+}
+
+// bestPeer asks every neighbour for its height and returns the highest.
+func (s *Syncer) bestPeer(neighbours []string) (string, int) {
+	best, bestHeight := "", -1
+	local := s.bc.Height()
+	for _, n := range neighbours {
+		hr, err := s.fetchHeaders(n, 0, 0)
+		if err != nil {
+			continue
+		}
+		if hr.Height < 0 || hr.Height > local+SYNC_MAX_HEIGHT_AHEAD {
+			log.Printf("ERROR: sync: %s claims implausible height %d", n, hr.Height)
+			s.bc.peers.Misbehaving(n, MisbehaviourImplausibleHeight)
+			continue
+		}
+		if hr.Height > bestHeight {
+			best, bestHeight = n, hr.Height
+		}
+	}
+	return best, bestHeight
+}
+
+func (s *Syncer) downloadHeaders(peer string, height int) ([]*BlockHeader, error) {
+	// height is what the peer claims, so it does not size anything.
+	headers := make([]*BlockHeader, 0, SYNC_HEADERS_BATCH)
+	for len(headers) <= height {
+		hr, err := s.fetchHeaders(peer, len(headers), SYNC_HEADERS_BATCH)
+		if err != nil {
+			return nil, err
+		}
+		if len(hr.Headers) == 0 {
+			break
+		}
+		// Validate the batch together with the header it builds on.
+		check := hr.Headers
+		if len(headers) > 0 {
+			check = append([]*BlockHeader{headers[len(headers)-1]}, hr.Headers...)
+		}
+		if !ValidHeaders(check, s.bc.params.MiningDifficulty) {
+			s.bc.peers.Misbehaving(peer, MisbehaviourBadProofOfWork)
+			return nil, fmt.Errorf("invalid headers from %s", peer)
+		}
+		headers = append(headers, hr.Headers...)
+		s.update(func(status *SyncStatus) {
+			status.Headers = len(headers)
+		})
+	}
+	if len(headers) == 0 || headers[0].Hash() != s.bc.Headers(0, 1)[0].Hash() {
+		s.bc.peers.Misbehaving(peer, MisbehaviourBadProofOfWork)
+		return nil, fmt.Errorf("%s has a different genesis block", peer)
+	}
+	if len(headers) <= s.bc.Height()+1 {
+		return nil, fmt.Errorf("%s did not provide a longer header chain", peer)
+	}
+	return headers, nil
+}
+
+// forkPoint returns the first height at which headers differ from the local
+// chain. Both chains share the genesis block.
+func (s *Syncer) forkPoint(headers []*BlockHeader) int {
+	local := s.bc.Headers(0, len(headers))
+	for i, h := range local {
+		if h.Hash() != headers[i].Hash() {
+			return i
+		}
+	}
+	return len(local)
+}
+
+type blockRange struct {
+	from, to int
+}
+
+// downloadBlocks fetches the bodies for headers[from:] in ranges, spread over
+// the neighbours, and checks each body against its header.
+func (s *Syncer) downloadBlocks(neighbours []string, headers []*BlockHeader, from int) ([]*Block, error) {
+	blocks := make([]*Block, len(headers)-from)
+	ranges := make(chan blockRange)
+	errs := make(chan error, SYNC_BLOCKS_WORKERS)
+	var wg sync.WaitGroup
+
+	for i := 0; i < SYNC_BLOCKS_WORKERS; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for r := range ranges {
+				if err := s.downloadRange(neighbours, worker, headers, r, blocks[r.from-from:r.to-from+1]); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+
+	go func() {
+		defer close(ranges)
+		for start := from; start < len(headers); start += SYNC_BLOCKS_BATCH {
+			end := start + SYNC_BLOCKS_BATCH - 1
+			if end >= len(headers) {
+				end = len(headers) - 1
+			}
+			select {
+			case ranges <- blockRange{start, end}:
+			case err := <-errs:
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	select {
+	case err := <-errs:
+		return nil, err
+	default:
+		return blocks, nil
+	}
+}
+
+func (s *Syncer) downloadRange(neighbours []string, worker int, headers []*BlockHeader, r blockRange, out []*Block) error {
+	for attempt := 0; attempt < SYNC_BLOCKS_ATTEMPTS*len(neighbours); attempt++ {
+		peer := neighbours[(worker+attempt)%len(neighbours)]
+		if s.bc.peers.IsBanned(peer) {
+			continue
+		}
+		blocks, err := s.fetchBlocks(peer, r.from, r.to)
+		if err != nil || len(blocks) != r.to-r.from+1 {
+			continue
+		}
+		valid := true
+		for i, b := range blocks {
+			if b.Hash() != headers[r.from+i].Hash() {
+				valid = false
+				break
+			}
+		}
+		if !valid {
+			s.bc.peers.Misbehaving(peer, MisbehaviourBadProofOfWork)
+			continue
+		}
+		copy(out, blocks)
+		s.update(func(status *SyncStatus) {
+			status.Blocks += len(blocks)
+		})
+		return nil
+	}
+	return fmt.Errorf("could not download blocks %d to %d", r.from, r.to)
+}
+
+// fetchHeaders and fetchBlocks use the wire protocol when connected to the
+// peer and fall back to HTTP otherwise.
+func (s *Syncer) fetchHeaders(peer string, from int, limit int) (*HeadersResponse, error) {
+	var hr *HeadersResponse
+	if s.bc.wire != nil && s.bc.wire.Connected(peer) {
+		var err error
+		if hr, err = s.bc.wire.Headers(peer, from, limit); err != nil {
+			return nil, err
+		}
+	} else {
+		hr = &HeadersResponse{}
+		path := fmt.Sprintf("/sync/headers?from=%d&limit=%d", from, limit)
+		if err := s.bc.getFromNeighbour(peer, path, hr); err != nil {
+			return nil, err
+		}
+	}
+	s.observe(peer, hr.Height)
+	return hr, nil
+}
+
+func (s *Syncer) fetchBlocks(peer string, from int, to int) ([]*Block, error) {
+	if s.bc.wire != nil && s.bc.wire.Connected(peer) {
+		return s.bc.wire.Blocks(peer, from, to)
+	}
+	var br BlocksResponse
+	path := fmt.Sprintf("/sync/blocks?from=%d&to=%d", from, to)
+	if err := s.bc.getFromNeighbour(peer, path, &br); err != nil {
+		return nil, err
+	}
+	return br.Blocks, nil
+}
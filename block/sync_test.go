@@ -0,0 +1,25 @@
+package block
+
+import "testing"
+
+func TestBestPeerHeight(t *testing.T) {
+	bc, _ := newTestBlockchain(t, 0)
+	s := bc.Syncer()
+	if h := s.BestPeerHeight(); h != -1 {
+		t.Fatalf("best peer height is %d with no reports, want -1", h)
+	}
+
+	bc.neighbours = []string{"127.0.0.1:5001", "127.0.0.1:5002"}
+	s.observe("127.0.0.1:5001", 5)
+	s.observe("127.0.0.1:5002", 9)
+	s.observe("127.0.0.1:5003", 20)
+	s.observe("127.0.0.1:5001", SYNC_MAX_HEIGHT_AHEAD+1)
+	if h := s.BestPeerHeight(); h != 9 {
+		t.Fatalf("best peer height is %d, want 9", h)
+	}
+
+	bc.peers.Misbehaving("127.0.0.1:5002", MisbehaviourBadProofOfWork)
+	if h := s.BestPeerHeight(); h != 5 {
+		t.Fatalf("best peer height is %d after a ban, want 5", h)
+	}
+}
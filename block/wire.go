@@ -0,0 +1,550 @@
+package block
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/palmcivet7/go-blockchain/wire"
+)
+
+const (
+	WIRE_PORT_OFFSET         = 1000
+	WIRE_DIAL_TIMEOUT_SEC    = 2
+	WIRE_PING_INTERVAL_SEC   = 30
+	WIRE_IDLE_TIMEOUT_SEC    = 90
+	WIRE_REQUEST_TIMEOUT_SEC = 10
+)
+
+var ErrNotConnected = errors.New("wire: not connected")
+
+// WireAddress is the TCP address a node serving HTTP on address accepts
+// wire connections on.
+func WireAddress(address string) (string, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port+WIRE_PORT_OFFSET)), nil
+}
+
+type wireConn struct {
+	address  string
+	outbound bool
+	conn     net.Conn
+	writeMux sync.Mutex
+
+	pending    map[uint32]chan wire.Message
+	pendingMux sync.Mutex
+}
+
+func (c *wireConn) send(msg wire.Message) error {
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second * WIRE_REQUEST_TIMEOUT_SEC))
+	return wire.WriteMessage(c.conn, msg)
+}
+
+// WireNode keeps persistent binary-protocol connections to neighbours. Peers
+// are keyed by their HTTP address, so scores, bans and gossip treat both
+// transports as the same peer.
+type WireNode struct {
+	bc            *Blockchain
+	conns         map[string]*wireConn
+	nextRequestID uint32
+	mux           sync.Mutex
+}
+
+func NewWireNode(bc *Blockchain) *WireNode {
+	return &WireNode{bc: bc, conns: make(map[string]*wireConn)}
+}
+
+func (wn *WireNode) Listen(address string) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	if wn.bc.serverTLS != nil {
+		ln = tls.NewListener(ln, wn.bc.serverTLS)
+	}
+	log.Printf("action=wire_listen, address=%s", address)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				return
+			}
+			go wn.handshake(conn, "", false)
+		}
+	}()
+	return nil
+}
+
+// ConnectNeighbours dials every neighbour we are not yet connected to.
+func (wn *WireNode) ConnectNeighbours() {
+	for _, n := range wn.bc.Neighbours() {
+		if wn.Connected(n) {
+			continue
+		}
+		address, err := WireAddress(n)
+		if err != nil {
+			continue
+		}
+		dialer := &net.Dialer{Timeout: time.Second * WIRE_DIAL_TIMEOUT_SEC}
+		var conn net.Conn
+		if wn.bc.clientTLS != nil {
+			conn, err = tls.DialWithDialer(dialer, "tcp", address, wn.bc.clientTLS)
+		} else {
+			conn, err = dialer.Dial("tcp", address)
+		}
+		if err != nil {
+			continue
+		}
+		go wn.handshake(conn, n, true)
+	}
+}
+
+func (wn *WireNode) Connected(peer string) bool {
+	wn.mux.Lock()
+	defer wn.mux.Unlock()
+	_, ok := wn.conns[peer]
+	return ok
+}
+
+// Send delivers msg to peer if there is a connection to it.
+func (wn *WireNode) Send(peer string, msg wire.Message) error {
+	wn.mux.Lock()
+	c, ok := wn.conns[peer]
+	wn.mux.Unlock()
+	if !ok {
+		return ErrNotConnected
+	}
+	return c.send(msg)
+}
+
+func (wn *WireNode) handshake(conn net.Conn, expected string, outbound bool) {
+	c := &wireConn{conn: conn, outbound: outbound, pending: make(map[uint32]chan wire.Message)}
+	r := bufio.NewReader(conn)
+
+	version := &wire.Version{
+		ProtocolVersion: wire.PROTOCOL_VERSION,
+		ChainID:         wn.bc.params.ChainID,
+		Address:         wn.bc.Address(),
+		Receiver:        expected,
+		Height:          uint64(wn.bc.Height()),
+	}
+	if err := c.send(version); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second * WIRE_REQUEST_TIMEOUT_SEC))
+	msg, err := wire.ReadMessage(r)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	theirs, ok := msg.(*wire.Version)
+	if !ok || theirs.ProtocolVersion != wire.PROTOCOL_VERSION || theirs.ChainID != wn.bc.params.ChainID ||
+		theirs.Address == wn.bc.Address() ||
+		(expected != "" && theirs.Address != expected) ||
+		(theirs.Receiver != "" && theirs.Receiver != wn.bc.Address()) {
+		conn.Close()
+		return
+	}
+	if !outbound {
+		host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+		if err != nil || wn.bc.peers.IsBanned(host) || !wn.bc.IsNeighbourAt(theirs.Address, host) {
+			conn.Close()
+			return
+		}
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		if outbound && !wn.bc.peers.BindIdentity(theirs.Address, &state) ||
+			!outbound && !wn.bc.peers.MatchesIdentity(theirs.Address, &state) {
+			conn.Close()
+			return
+		}
+	}
+	c.address = theirs.Address
+	if wn.bc.peers.IsBanned(c.address) || !wn.register(c) {
+		conn.Close()
+		return
+	}
+	log.Printf("action=wire_connect, peer=%s, outbound=%t", c.address, outbound)
+	wn.bc.peers.Seen(c.address)
+	wn.bc.syncer.observe(c.address, int(theirs.Height))
+
+	go wn.ping(c)
+	wn.read(c, r)
+}
+
+// register adds c unless there already is a connection to the same peer. When
+// two nodes dial each other at once, both keep the connection opened by the
+// node with the lower address.
+func (wn *WireNode) register(c *wireConn) bool {
+	wn.mux.Lock()
+	defer wn.mux.Unlock()
+
+	existing, ok := wn.conns[c.address]
+	if ok {
+		keepOutbound := wn.bc.Address() < c.address
+		if c.outbound != keepOutbound || existing.outbound == keepOutbound {
+			return false
+		}
+		existing.conn.Close()
+	}
+	wn.conns[c.address] = c
+	return true
+}
+
+func (wn *WireNode) unregister(c *wireConn) {
+	wn.mux.Lock()
+	if wn.conns[c.address] == c {
+		delete(wn.conns, c.address)
+	}
+	wn.mux.Unlock()
+
+	c.conn.Close()
+	c.pendingMux.Lock()
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.pendingMux.Unlock()
+}
+
+func (wn *WireNode) ping(c *wireConn) {
+	ticker := time.NewTicker(time.Second * WIRE_PING_INTERVAL_SEC)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := c.send(&wire.Ping{Nonce: rand.Uint64()}); err != nil {
+			return
+		}
+	}
+}
+
+func (wn *WireNode) read(c *wireConn, r *bufio.Reader) {
+	defer wn.unregister(c)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(time.Second * WIRE_IDLE_TIMEOUT_SEC))
+		msg, err := wire.ReadMessage(r)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				wn.bc.peers.Misbehaving(c.address, MisbehaviourTimeout)
+			} else if !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+				wn.bc.peers.Misbehaving(c.address, MisbehaviourMalformedJSON)
+			}
+			log.Printf("action=wire_disconnect, peer=%s, reason=%v", c.address, err)
+			return
+		}
+		wn.bc.peers.Seen(c.address)
+		if err := wn.handle(c, msg); err != nil {
+			log.Printf("ERROR: wire %s from %s: %v", msg.Command(), c.address, err)
+			return
+		}
+		if wn.bc.peers.IsBanned(c.address) {
+			return
+		}
+	}
+}
+
+func (wn *WireNode) handle(c *wireConn, msg wire.Message) error {
+	switch m := msg.(type) {
+	case *wire.Ping:
+		return c.send(&wire.Pong{Nonce: m.Nonce})
+	case *wire.Pong:
+		return nil
+	case *wire.Inv:
+		missing := wn.bc.gossip.filterMissing(fromWireItems(m.Items))
+		if len(missing) == 0 {
+			return nil
+		}
+		if err := c.send(&wire.GetData{Items: toWireItems(missing)}); err != nil {
+			wn.bc.gossip.forget(missing)
+			return err
+		}
+		return nil
+	case *wire.GetData:
+		resp := wn.bc.gossip.GetData(&GetDataRequest{fromWireItems(m.Items)})
+		for _, tr := range resp.Transactions {
+			t, err := toWireRequest(tr)
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				continue
+			}
+			if err := c.send(&wire.Tx{Transaction: t}); err != nil {
+				return err
+			}
+		}
+		for _, b := range resp.Blocks {
+			if err := c.send(toWireBlock(b)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *wire.Tx:
+		if item, ok := wn.bc.gossip.receiveTransaction(c.address, fromWireRequest(&m.Transaction)); ok {
+			wn.bc.gossip.Announce([]InvItem{item}, c.address)
+		}
+		return nil
+	case *wire.Block:
+		if item, ok := wn.bc.gossip.receiveBlock(c.address, fromWireBlock(m)); ok {
+			wn.bc.gossip.Announce([]InvItem{item}, c.address)
+		}
+		return nil
+	case *wire.GetHeaders:
+		limit := int(m.Limit)
+		if limit > SYNC_HEADERS_BATCH {
+			limit = SYNC_HEADERS_BATCH
+		}
+		headers := wn.bc.Headers(int(m.From), limit)
+		resp := &wire.Headers{RequestID: m.RequestID, Height: uint64(wn.bc.Height()), Headers: make([]wire.Header, len(headers))}
+		for i, h := range headers {
+			resp.Headers[i] = toWireHeader(h)
+		}
+		return c.send(resp)
+	case *wire.GetBlocks:
+		count := int(m.Count)
+		if count > SYNC_BLOCKS_BATCH {
+			count = SYNC_BLOCKS_BATCH
+		}
+		blocks := wn.bc.Blocks(int(m.From), int(m.From)+count-1)
+		resp := &wire.Blocks{RequestID: m.RequestID, Blocks: make([]wire.Block, len(blocks))}
+		for i, b := range blocks {
+			resp.Blocks[i] = *toWireBlock(b)
+		}
+		return c.send(resp)
+	case *wire.Headers:
+		c.respond(m.RequestID, m)
+		return nil
+	case *wire.Blocks:
+		c.respond(m.RequestID, m)
+		return nil
+	case *wire.Version:
+		return fmt.Errorf("unexpected version after handshake")
+	}
+	return nil
+}
+
+func (c *wireConn) respond(id uint32, msg wire.Message) {
+	c.pendingMux.Lock()
+	ch, ok := c.pending[id]
+	delete(c.pending, id)
+	c.pendingMux.Unlock()
+	if ok {
+		ch <- msg
+	}
+}
+
+// request sends a message built with a fresh request ID and waits for the
+// response carrying the same ID.
+func (wn *WireNode) request(peer string, build func(id uint32) wire.Message) (wire.Message, error) {
+	wn.mux.Lock()
+	c, ok := wn.conns[peer]
+	wn.mux.Unlock()
+	if !ok {
+		return nil, ErrNotConnected
+	}
+
+	id := atomic.AddUint32(&wn.nextRequestID, 1)
+	ch := make(chan wire.Message, 1)
+	c.pendingMux.Lock()
+	c.pending[id] = ch
+	c.pendingMux.Unlock()
+
+	if err := c.send(build(id)); err != nil {
+		return nil, err
+	}
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return nil, ErrNotConnected
+		}
+		return msg, nil
+	case <-time.After(time.Second * WIRE_REQUEST_TIMEOUT_SEC):
+		c.pendingMux.Lock()
+		delete(c.pending, id)
+		c.pendingMux.Unlock()
+		wn.bc.peers.Misbehaving(peer, MisbehaviourTimeout)
+		return nil, fmt.Errorf("wire: request to %s timed out", peer)
+	}
+}
+
+func (wn *WireNode) Headers(peer string, from int, limit int) (*HeadersResponse, error) {
+	msg, err := wn.request(peer, func(id uint32) wire.Message {
+		return &wire.GetHeaders{RequestID: id, From: uint64(from), Limit: uint32(limit)}
+	})
+	if err != nil {
+		return nil, err
+	}
+	m, ok := msg.(*wire.Headers)
+	if !ok {
+		wn.bc.peers.Misbehaving(peer, MisbehaviourUnexpectedMessage)
+		return nil, fmt.Errorf("wire: %s answered getheaders with %s", peer, msg.Command())
+	}
+	hr := &HeadersResponse{Headers: make([]*BlockHeader, len(m.Headers)), Height: int(m.Height)}
+	for i := range m.Headers {
+		hr.Headers[i] = fromWireHeader(&m.Headers[i])
+	}
+	return hr, nil
+}
+
+func (wn *WireNode) Blocks(peer string, from int, to int) ([]*Block, error) {
+	msg, err := wn.request(peer, func(id uint32) wire.Message {
+		return &wire.GetBlocks{RequestID: id, From: uint64(from), Count: uint32(to - from + 1)}
+	})
+	if err != nil {
+		return nil, err
+	}
+	m, ok := msg.(*wire.Blocks)
+	if !ok {
+		wn.bc.peers.Misbehaving(peer, MisbehaviourUnexpectedMessage)
+		return nil, fmt.Errorf("wire: %s answered getblocks with %s", peer, msg.Command())
+	}
+	blocks := make([]*Block, len(m.Blocks))
+	for i := range m.Blocks {
+		blocks[i] = fromWireBlock(&m.Blocks[i])
+	}
+	return blocks, nil
+}
+
+func toWireItems(items []InvItem) []wire.InvItem {
+	wireItems := make([]wire.InvItem, 0, len(items))
+	for _, item := range items {
+		hash, err := hashFromString(item.Hash)
+		if err != nil {
+			continue
+		}
+		t := wire.InvTypeTx
+		if item.Type == InvTypeBlock {
+			t = wire.InvTypeBlock
+		}
+		wireItems = append(wireItems, wire.InvItem{Type: t, Hash: hash})
+	}
+	return wireItems
+}
+
+func fromWireItems(wireItems []wire.InvItem) []InvItem {
+	items := make([]InvItem, 0, len(wireItems))
+	for _, item := range wireItems {
+		switch item.Type {
+		case wire.InvTypeTx:
+			items = append(items, InvItem{InvTypeTransaction, hashString(item.Hash)})
+		case wire.InvTypeBlock:
+			items = append(items, InvItem{InvTypeBlock, hashString(item.Hash)})
+		}
+	}
+	return items
+}
+
+func toWireHeader(h *BlockHeader) wire.Header {
+	return wire.Header{
+		Height:           uint64(h.height),
+		Timestamp:        h.timestamp,
+		Nonce:            int64(h.nonce),
+		PreviousHash:     h.previousHash,
+		TransactionsHash: h.transactionsHash,
+		ExtraData:        h.extraData,
+	}
+}
+
+func fromWireHeader(h *wire.Header) *BlockHeader {
+	return &BlockHeader{h.Timestamp, int(h.Nonce), h.PreviousHash, h.TransactionsHash, h.ExtraData, int(h.Height)}
+}
+
+func toWireBlock(b *Block) *wire.Block {
+	wb := &wire.Block{
+		Height:       uint64(b.height),
+		Timestamp:    b.timestamp,
+		Nonce:        int64(b.nonce),
+		PreviousHash: b.previousHash,
+		Transactions: make([]wire.Transaction, len(b.transactions)),
+		ExtraData:    b.extraData,
+	}
+	for i, t := range b.transactions {
+		wb.Transactions[i] = wire.Transaction{Sender: t.senderAddress, Receiver: t.receiverAddress, Value: t.value, Fee: t.fee, Nonce: t.nonce}
+	}
+	return wb
+}
+
+func fromWireBlock(wb *wire.Block) *Block {
+	transactions := make([]*Transaction, len(wb.Transactions))
+	for i, t := range wb.Transactions {
+		transactions[i] = NewTransaction(t.Sender, t.Receiver, t.Value, t.Fee, t.Nonce)
+	}
+	return &Block{wb.Timestamp, int(wb.Nonce), wb.PreviousHash, transactions, wb.ExtraData, int(wb.Height)}
+}
+
+func toWireRequest(tr *TransactionRequest) (wire.Transaction, error) {
+	publicKey, signature, err := tr.Keys()
+	if err != nil {
+		return wire.Transaction{}, err
+	}
+	return wire.Transaction{
+		Sender:    *tr.SenderAddress,
+		Receiver:  *tr.ReceiverAddress,
+		Value:     *tr.Value,
+		Fee:       tr.FeeValue(),
+		Nonce:     tr.NonceValue(),
+		PublicKey: append(fixedBytes(publicKey.X), fixedBytes(publicKey.Y)...),
+		Signature: append(fixedBytes(signature.R), fixedBytes(signature.S)...),
+	}, nil
+}
+
+// fromWireRequest returns nil if the key or signature is not 64 bytes.
+func fromWireRequest(t *wire.Transaction) *TransactionRequest {
+	if len(t.PublicKey) != 64 || len(t.Signature) != 64 {
+		return nil
+	}
+	sender, receiver, value, fee, nonce := t.Sender, t.Receiver, t.Value, t.Fee, t.Nonce
+	publicKeyStr := fmt.Sprintf("%x", t.PublicKey)
+	signatureStr := fmt.Sprintf("%x", t.Signature)
+	return &TransactionRequest{&sender, &receiver, &publicKeyStr, &value, &fee, &nonce, &signatureStr}
+}
+
+func fixedBytes(i *big.Int) []byte {
+	b := make([]byte, 32)
+	return i.FillBytes(b)
+}
+
+// RawTransaction serializes a signed transaction request in the raw
+// transaction format.
+func (tr *TransactionRequest) RawTransaction() ([]byte, error) {
+	t, err := toWireRequest(tr)
+	if err != nil {
+		return nil, err
+	}
+	return wire.EncodeRawTransaction(&t), nil
+}
+
+func TransactionRequestFromRaw(raw []byte) (*TransactionRequest, error) {
+	t, err := wire.DecodeRawTransaction(raw)
+	if err != nil {
+		return nil, err
+	}
+	return fromWireRequest(t), nil
+}
+
+func TransactionRequestFromRawHex(s string) (*TransactionRequest, error) {
+	t, err := wire.DecodeRawTransactionHex(s)
+	if err != nil {
+		return nil, err
+	}
+	return fromWireRequest(t), nil
+}
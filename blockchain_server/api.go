@@ -0,0 +1,301 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/palmcivet7/go-blockchain/block"
+	"github.com/palmcivet7/go-blockchain/utils"
+)
+
+// Bodies of the REST API that are not types of the block package.
+
+type statusResponse struct {
+	Message string `json:"message"`
+}
+
+type submitResponse struct {
+	Message string `json:"message"`
+	Hash    string `json:"hash"`
+}
+
+type rawTransactionRequest struct {
+	RawTransaction *string `json:"raw_transaction"`
+}
+
+type transactionsResponse struct {
+	Transactions []*block.Transaction `json:"transactions"`
+	Length       int                  `json:"length"`
+}
+
+type nonceResponse struct {
+	Nonce          uint64 `json:"nonce"`
+	ConfirmedNonce uint64 `json:"confirmed_nonce"`
+}
+
+type addressTransactionsResponse struct {
+	Address      string                      `json:"address"`
+	Transactions []*block.AddressTransaction `json:"transactions"`
+	Total        int                         `json:"total"`
+	Next         *int                        `json:"next,omitempty"`
+}
+
+type peersResponse struct {
+	Peers  []*block.Peer `json:"peers"`
+	Length int           `json:"length"`
+}
+
+// blocksPageResponse is what BlocksPage streams. With headers=true the
+// blocks are block headers.
+type blocksPageResponse struct {
+	Blocks []*block.Block `json:"blocks"`
+	Next   *int           `json:"next,omitempty"`
+	Height int            `json:"height"`
+}
+
+type apiParam struct {
+	name        string
+	in          string // "query" or "path"
+	kind        string // JSON schema type of the value
+	required    bool
+	description string
+}
+
+// apiOperation documents one method of a route. request and response are
+// values of the body types, nil when there is no body.
+type apiOperation struct {
+	method      string
+	path        string // OpenAPI path when the route serves several
+	summary     string
+	params      []apiParam
+	request     interface{}
+	binary      bool // the body may also be application/octet-stream
+	status      int
+	response    interface{}
+	description string
+	admin       bool // requires the admin token
+}
+
+// apiRoute is one handler of the REST API. The same tables register the
+// handlers and generate the OpenAPI document, so the two cannot disagree.
+type apiRoute struct {
+	pattern    string
+	legacy     string // deprecated unversioned path, if it had one
+	handler    http.HandlerFunc
+	operations []apiOperation
+}
+
+var addressParam = apiParam{"blockchain_address", "query", "string", true, "address to look up"}
+
+// apiRoutes are served under /api/v1.
+func (bcs *BlockchainServer) apiRoutes() []apiRoute {
+	return []apiRoute{
+		{"/chain", "/", bcs.GetChain, []apiOperation{
+			{method: http.MethodGet, summary: "The whole chain", response: &block.Blockchain{}},
+		}},
+		{"/transactions", "/transactions", bcs.Transactions, []apiOperation{
+			{method: http.MethodGet, summary: "Transactions in the mempool", response: &transactionsResponse{}},
+			{method: http.MethodPost, summary: "Submit a signed transaction", request: &block.TransactionRequest{}, status: http.StatusCreated, response: &submitResponse{}},
+			{method: http.MethodDelete, summary: "Clear the mempool", response: &statusResponse{}, admin: true},
+		}},
+		{"/transactions/raw", "/transactions/raw", bcs.RawTransaction, []apiOperation{
+			{method: http.MethodPost, summary: "Submit a raw signed transaction", request: &rawTransactionRequest{}, binary: true, status: http.StatusCreated, response: &submitResponse{},
+				description: "The body is the raw transaction itself when sent as application/octet-stream."},
+		}},
+		{"/mempool/stats", "/mempool/stats", bcs.MempoolStats, []apiOperation{
+			{method: http.MethodGet, summary: "Mempool size, limits and fee histogram", response: &block.MempoolStats{}},
+		}},
+		{"/mine", "/mine", bcs.Mine, []apiOperation{
+			{method: http.MethodGet, summary: "Mine one block", response: &statusResponse{}, admin: true},
+		}},
+		{"/mine/start", "/mine/start", bcs.StartMine, []apiOperation{
+			{method: http.MethodGet, summary: "Start mining continuously", response: &statusResponse{}, admin: true},
+		}},
+		{"/mine/stop", "", bcs.StopMine, []apiOperation{
+			{method: http.MethodGet, summary: "Stop mining continuously", response: &statusResponse{}, admin: true},
+		}},
+		{"/amount", "/amount", bcs.Amount, []apiOperation{
+			{method: http.MethodGet, summary: "Confirmed balance of an address", params: []apiParam{addressParam}, response: &block.AmountResponse{}},
+		}},
+		{"/nonce", "/nonce", bcs.Nonce, []apiOperation{
+			{method: http.MethodGet, summary: "Nonce of an address's next transaction", params: []apiParam{addressParam}, response: &nonceResponse{}},
+		}},
+		{"/address/", "/address/", bcs.AddressTransactions, []apiOperation{
+			{method: http.MethodGet, path: "/address/{address}/transactions", summary: "Confirmed transactions of an address, newest first", params: []apiParam{
+				{"address", "path", "string", true, ""},
+				{"direction", "query", "string", false, "in or out"},
+				{"offset", "query", "integer", false, ""},
+				{"limit", "query", "integer", false, ""},
+			}, response: &addressTransactionsResponse{}},
+		}},
+		{"/admin/peers", "/admin/peers", bcs.AdminPeers, []apiOperation{
+			{method: http.MethodGet, summary: "Known peers and their scores", response: &peersResponse{}, admin: true},
+			{method: http.MethodDelete, summary: "Lift a peer's ban and clear its score", params: []apiParam{
+				{"address", "query", "string", true, "peer address"},
+			}, response: &statusResponse{}, admin: true},
+		}},
+		{"/admin/shutdown", "", bcs.Shutdown, []apiOperation{
+			{method: http.MethodPost, summary: "Stop the node", response: &statusResponse{}, admin: true},
+		}},
+		{"/blocks", "/blocks", bcs.BlocksPage, []apiOperation{
+			{method: http.MethodGet, summary: "A page of blocks in height order", params: []apiParam{
+				{"from", "query", "integer", false, ""},
+				{"to", "query", "integer", false, ""},
+				{"limit", "query", "integer", false, ""},
+				{"headers", "query", "boolean", false, "send block headers only"},
+			}, response: &blocksPageResponse{}},
+		}},
+		{"/blocks/", "/blocks/", bcs.Blocks, []apiOperation{
+			{method: http.MethodGet, path: "/blocks/tip", summary: "The newest block", response: &block.Block{}},
+			{method: http.MethodGet, path: "/blocks/{height}", summary: "The block at a height", params: []apiParam{
+				{"height", "path", "integer", true, ""},
+			}, response: &block.Block{}},
+			{method: http.MethodGet, path: "/blocks/hash/{hash}", summary: "The block with a hash", params: []apiParam{
+				{"hash", "path", "string", true, ""},
+			}, response: &block.Block{}},
+		}},
+		{"/sync/status", "/sync/status", bcs.SyncStatus, []apiOperation{
+			{method: http.MethodGet, summary: "Progress of the initial sync", response: &block.SyncStatus{}},
+		}},
+		{"/ws", "/ws", bcs.WebSocket, []apiOperation{
+			{method: http.MethodGet, summary: "Subscribe to events over a WebSocket", status: http.StatusSwitchingProtocols,
+				description: "Clients send wsRequest messages and receive wsMessage messages. " +
+					"Pages may only connect from the node's own origin or one given with -ws_origins."},
+		}},
+		{"/rpc", "/rpc", bcs.RPC, []apiOperation{
+			{method: http.MethodPost, summary: "JSON-RPC 2.0", request: &rpcRequest{}, response: &rpcResponse{},
+				description: "The body may also be a batch, an array of requests answered by an array of responses. " +
+					"The getPeers, mine, startMining and stopMining methods require the admin token."},
+		}},
+		{"/limits", "", bcs.Limits, []apiOperation{
+			{method: http.MethodGet, summary: "What the request limits did to each endpoint", response: map[string]utils.LimitStats{}},
+		}},
+		{"/openapi.json", "", bcs.OpenAPI, []apiOperation{
+			{method: http.MethodGet, summary: "This document"},
+		}},
+	}
+}
+
+// apiConcurrency caps the requests in flight on the costlier routes. The
+// others allow utils.MAX_CONCURRENT_REQUESTS.
+var apiConcurrency = map[string]int{
+	"/chain":  4,
+	"/blocks": 8,
+	"/ws":     256,
+}
+
+// peerConcurrency caps the peer routes in flight, as apiConcurrency does
+// the API routes.
+var peerConcurrency = map[string]int{
+	"/sync/headers": 8,
+	"/sync/blocks":  8,
+}
+
+// peerRoutes make up the node-to-node protocol. Nodes of every version
+// must agree on them, so they are not versioned with the API.
+func (bcs *BlockchainServer) peerRoutes() []apiRoute {
+	return []apiRoute{
+		{"/sync/headers", "", bcs.SyncHeaders, []apiOperation{
+			{method: http.MethodGet, summary: "Block headers from a height", params: []apiParam{
+				{"from", "query", "integer", false, ""},
+				{"limit", "query", "integer", false, ""},
+			}, response: &block.HeadersResponse{}},
+		}},
+		{"/sync/blocks", "", bcs.SyncBlocks, []apiOperation{
+			{method: http.MethodGet, summary: "Blocks between two heights", params: []apiParam{
+				{"from", "query", "integer", false, ""},
+				{"to", "query", "integer", false, ""},
+			}, response: &block.BlocksResponse{}},
+		}},
+		{"/inv", "", bcs.Inv, []apiOperation{
+			{method: http.MethodPost, summary: "Announce transactions and blocks", request: &block.InvMessage{}, response: &statusResponse{}},
+		}},
+		{"/getdata", "", bcs.GetData, []apiOperation{
+			{method: http.MethodPost, summary: "Fetch announced transactions and blocks", request: &block.GetDataRequest{}, response: &block.GetDataResponse{}},
+		}},
+	}
+}
+
+// rootRoutes are served outside /api/v1, where tools expect to find them.
+func (bcs *BlockchainServer) rootRoutes() []apiRoute {
+	return []apiRoute{
+		{"/metrics", "", bcs.metrics.Metrics, []apiOperation{
+			{method: http.MethodGet, summary: "Prometheus metrics", description: "In the Prometheus text exposition format."},
+		}},
+	}
+}
+
+// deprecated serves a legacy path, pointing clients at the same resource
+// under /api/v1.
+func deprecated(route apiRoute) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The chain was served at "/", which as a pattern matches everything.
+		if route.legacy == "/" && r.URL.Path != "/" {
+			notFound(w, r)
+			return
+		}
+		successor := utils.API_V1_PREFIX + route.pattern + strings.TrimPrefix(r.URL.Path, route.legacy)
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		route.handler(w, r)
+	}
+}
+
+// authorize requires the admin token for the route's admin operations.
+func (bcs *BlockchainServer) authorize(route apiRoute) http.HandlerFunc {
+	admin := map[string]bool{}
+	for _, o := range route.operations {
+		if o.admin {
+			admin[o.method] = true
+		}
+	}
+	if len(admin) == 0 {
+		return route.handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if admin[r.Method] && !bcs.isAdmin(r) {
+			utils.Unauthorized(w)
+			return
+		}
+		route.handler(w, r)
+	}
+}
+
+func (bcs *BlockchainServer) isAdmin(r *http.Request) bool {
+	return utils.Authorized(r, bcs.opts.AdminToken)
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	utils.WriteError(w, utils.NotFound("not found"))
+}
+
+// NewMux routes /api/v1, the peer protocol and the deprecated unversioned
+// paths.
+func (bcs *BlockchainServer) NewMux() *http.ServeMux {
+	api := http.NewServeMux()
+	api.HandleFunc("/", notFound)
+	mux := http.NewServeMux()
+	for _, route := range bcs.apiRoutes() {
+		concurrency, ok := apiConcurrency[route.pattern]
+		if !ok {
+			concurrency = utils.MAX_CONCURRENT_REQUESTS
+		}
+		route.handler = bcs.metrics.Instrument(route.pattern, bcs.opts.Limiter.Limit(route.pattern, concurrency, bcs.authorize(route)))
+		api.HandleFunc(route.pattern, route.handler)
+		if route.legacy != "" {
+			mux.HandleFunc(route.legacy, deprecated(route))
+		}
+	}
+	for _, route := range bcs.peerRoutes() {
+		concurrency, ok := peerConcurrency[route.pattern]
+		if !ok {
+			concurrency = utils.MAX_CONCURRENT_REQUESTS
+		}
+		mux.HandleFunc(route.pattern, bcs.metrics.Instrument(route.pattern, bcs.opts.PeerLimiter.Limit(route.pattern, concurrency, route.handler)))
+	}
+	for _, route := range bcs.rootRoutes() {
+		mux.HandleFunc(route.pattern, route.handler)
+	}
+	mux.Handle(utils.API_V1_PREFIX+"/", http.StripPrefix(utils.API_V1_PREFIX, api))
+	return mux
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/palmcivet7/go-blockchain/block"
+	"github.com/palmcivet7/go-blockchain/utils"
+)
+
+func adminRequest(t *testing.T, method string, url string, token string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestAdminRoutesRequireToken(t *testing.T) {
+	bcs, srv, w := newFundedTestServer(t, 100)
+	addSigned(t, bcs, w, "bob", 1, 0)
+	peer := "127.0.0.1:5001"
+	bcs.peers.Misbehaving(peer, block.MisbehaviourInvalidBlock)
+
+	for _, c := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/mine"},
+		{http.MethodGet, "/admin/peers"},
+		{http.MethodDelete, "/admin/peers?address=" + peer},
+	} {
+		url := srv.URL + utils.API_V1_PREFIX + c.path
+		for _, token := range []string{"", "wrong"} {
+			resp := adminRequest(t, c.method, url, token)
+			if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+				t.Errorf("%s %s with token %q: %d, want %d", c.method, c.path, token, resp.StatusCode, http.StatusUnauthorized)
+			}
+		}
+		if resp := adminRequest(t, c.method, url, bcs.opts.AdminToken); resp.StatusCode != http.StatusOK {
+			t.Errorf("%s %s with the admin token: %d, want %d", c.method, c.path, resp.StatusCode, http.StatusOK)
+		}
+	}
+	if bcs.GetBlockchain().Height() != 1 {
+		t.Error("nothing was mined with the admin token")
+	}
+	if bcs.peers.IsBanned(peer) {
+		t.Error("the peer is still banned after being forgiven")
+	}
+}
+
+func TestAdminRPCRequiresToken(t *testing.T) {
+	bcs, srv := newTestServer(t)
+	body := `{"jsonrpc": "2.0", "method": "getPeers", "id": 1}`
+	for _, token := range []string{"", "wrong"} {
+		if status, m := postRPC(t, srv.URL, body, token); status != http.StatusUnauthorized {
+			t.Errorf("token %q: %d %s, want %d", token, status, m, http.StatusUnauthorized)
+		}
+	}
+	if status, m := postRPC(t, srv.URL, body, bcs.opts.AdminToken); status != http.StatusOK {
+		t.Errorf("the admin token: %d %s, want %d", status, m, http.StatusOK)
+	}
+
+	// Within a batch the other calls are answered.
+	batch := `[` + body + `, {"jsonrpc": "2.0", "method": "getBlockCount", "id": 2}]`
+	if status, m := postRPC(t, srv.URL, batch, ""); status != http.StatusOK {
+		t.Errorf("batch: %d %s, want %d", status, m, http.StatusOK)
+	}
+}
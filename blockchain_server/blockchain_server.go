@@ -1,25 +1,66 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/palmcivet7/go-blockchain/block"
 	"github.com/palmcivet7/go-blockchain/utils"
 	"github.com/palmcivet7/go-blockchain/wallet"
+	"github.com/palmcivet7/go-blockchain/wire"
+)
+
+const (
+	BLOCKS_PAGE_LIMIT = 20
+	BLOCKS_PAGE_MAX_LIMIT = 1000
+
+	ADDRESS_TRANSACTIONS_LIMIT = 20
+	ADDRESS_TRANSACTIONS_MAX_LIMIT = 500
+
+	SHUTDOWN_TIMEOUT = 10 * time.Second
 )
 
 var cache map[string]*block.Blockchain = make(map[string]*block.Blockchain)
 
+// Options holds the optional node features chosen on the command line.
+type Options struct {
+	Params		*block.ChainParams
+	Mempool		*block.Mempool
+	InitialSync	bool
+	Wire		bool
+	ServerTLS	*tls.Config
+	ClientTLS	*tls.Config
+	// AdminToken authorizes mining control, clearing the mempool, peer
+	// management and shutdown.
+	AdminToken	string
+	// Limiter throttles the REST API, PeerLimiter the peer protocol.
+	Limiter		*utils.Limiter
+	PeerLimiter	*utils.Limiter
+	// WSOrigins are the pages besides the node's own that may open a
+	// WebSocket.
+	WSOrigins	[]string
+}
+
 type BlockchainServer struct {
-	port uint16
+	port		uint16
+	peers		*block.PeerManager
+	opts		*Options
+	server		*http.Server
+	stopped		chan struct{}
+	metrics		*utils.Registry
 }
 
-func NewBlockchainServer(port uint16) *BlockchainServer {
-	return &BlockchainServer{port}
+func NewBlockchainServer(port uint16, peers *block.PeerManager, opts *Options) *BlockchainServer {
+	return &BlockchainServer{port, peers, opts, nil, make(chan struct{}), utils.NewRegistry()}
 }
 
 func (bcs *BlockchainServer) Port() uint16 {
@@ -29,8 +70,11 @@ func (bcs *BlockchainServer) Port() uint16 {
 func (bcs *BlockchainServer) GetBlockchain() *block.Blockchain {
 	bc, ok := cache["blockchain"]
 	if !ok {
-		minersWallet := wallet.NewWallet()
-		bc = block.NewBlockchain(minersWallet.BlockchainAddress(), bcs.Port())
+		minersWallet := wallet.NewWallet(bcs.opts.Params.AddressVersion)
+		bc = block.NewBlockchain(minersWallet.BlockchainAddress(), bcs.Port(), bcs.peers, bcs.opts.Mempool, bcs.opts.Params)
+		if bcs.opts.ServerTLS != nil {
+			bc.UseTLS(bcs.opts.ServerTLS, bcs.opts.ClientTLS)
+		}
 		cache["blockchain"] = bc
 		log.Printf("private_key %v", minersWallet.PrivateKeyStr())
 		log.Printf("public_key %v", minersWallet.PublicKeyStr())
@@ -39,15 +83,39 @@ func (bcs *BlockchainServer) GetBlockchain() *block.Blockchain {
 	return bc 
 }
 
+// checkPeer refuses node-to-node requests from banned peers and from nodes
+// on another network. It returns the IP misbehaviour is scored against and
+// the neighbour the request comes from, "" unless the address it names is
+// one of ours on that IP.
+func (bcs *BlockchainServer) checkPeer(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	ip := utils.ClientIP(r)
+	neighbour := r.Header.Get(block.NODE_ADDRESS_HEADER)
+	// Over TLS the neighbour must also present the certificate it did when
+	// we dialed it.
+	if !bcs.GetBlockchain().IsNeighbourAt(neighbour, ip) || !bcs.peers.MatchesIdentity(neighbour, r.TLS) {
+		neighbour = ""
+	}
+	if bcs.peers.IsBanned(ip) || (neighbour != "" && bcs.peers.IsBanned(neighbour)) {
+		utils.WriteError(w, utils.NewAPIError(http.StatusForbidden, utils.ERR_CODE_BANNED, "banned"))
+		return ip, neighbour, false
+	}
+	chainID := r.Header.Get(block.NODE_CHAIN_ID_HEADER)
+	if chainID != "" && chainID != strconv.Itoa(int(bcs.opts.Params.ChainID)) {
+		utils.WriteError(w, utils.NewAPIError(http.StatusForbidden, utils.ERR_CODE_WRONG_NETWORK, "wrong network"))
+		return ip, neighbour, false
+	}
+	return ip, neighbour, true
+}
+
 func (bcs *BlockchainServer) GetChain(w http.ResponseWriter, r *http.Request) {
 	switch r.Method{
 	case http.MethodGet:
-		w.Header().Add("Content-Type", "application-json")
+		w.Header().Add("Content-Type", "application/json")
 		bc := bcs.GetBlockchain()
 		m, _ := bc.MarshalJSON()
 		io.WriteString(w, string(m[:])) 
 	default:
-		log.Printf("ERROR: Invalid HTTP Method")
+		utils.MethodNotAllowed(w, http.MethodGet)
 	}
 }
 
@@ -57,10 +125,7 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, r *http.Request
 		w.Header().Add("Content-Type", "application/json")
 		bc := bcs.GetBlockchain()
 		transactions := bc.TransactionPool()
-		m, _ := json.Marshal(struct{
-			Transactions	[]*block.Transaction    `json:"transactions"`
-			Length 			int						`json:"length"`
-		}{
+		m, _ := json.Marshal(&transactionsResponse{
 			Transactions: transactions,
 			Length: len(transactions),
 		})
@@ -72,79 +137,117 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, r *http.Request
 		err := decoder.Decode(&t)
 		if err != nil {
 			log.Printf("ERROR: %v", err)
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+			utils.WriteError(w, utils.DecodeError(err))
 			return
 		}
 		if !t.Validate() {
 			log.Printf("ERROR: Missing field(s)")
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+			utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_MISSING_FIELD, "missing field(s)"))
 			return
 		}
-		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		signature := utils.SignatureFromString(*t.Signature)
+		bcs.submitTransaction(w, &t)
+
+	case http.MethodDelete:
 		bc := bcs.GetBlockchain()
-		isCreated := bc.CreateTransaction(
-			*t.SenderAddress,
-			*t.ReceiverAddress,
-			*t.Value,
-			publicKey,
-			signature,
-		)
-
-		w.Header().Add("Content-Type", "application/json")
-		var m []byte
-		if !isCreated {
-			w.WriteHeader(http.StatusBadRequest)
-			m = utils.JsonStatus("fail")
+		bc.ClearTransactionPool()
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(utils.JsonStatus("success")))
+	
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet, http.MethodPost, http.MethodDelete)
+	}
+}
+
+// submitTransaction adds a transaction sent by a client and answers with
+// its hash.
+func (bcs *BlockchainServer) submitTransaction(w http.ResponseWriter, t *block.TransactionRequest) {
+	publicKey, signature, err := t.Keys()
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		utils.WriteError(w, transactionError(err))
+		return
+	}
+	bc := bcs.GetBlockchain()
+	err = bc.CreateTransaction(
+		*t.SenderAddress,
+		*t.ReceiverAddress,
+		*t.Value,
+		t.FeeValue(),
+		t.NonceValue(),
+		publicKey,
+		signature,
+	)
+
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		utils.WriteError(w, transactionError(err))
+		return
+	}
+	hash := block.NewTransaction(*t.SenderAddress, *t.ReceiverAddress, *t.Value, t.FeeValue(), t.NonceValue()).Hash()
+	m, _ := json.Marshal(&submitResponse{
+		Message: "success",
+		Hash: hex.EncodeToString(hash[:]),
+	})
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, string(m))
+}
+
+// RawTransaction accepts a signed transaction in the raw transaction
+// format, either as the binary body of an application/octet-stream request
+// or hex encoded as {"raw_transaction": "..."}.
+func (bcs *BlockchainServer) RawTransaction(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var t *block.TransactionRequest
+		var err error
+		// The media type may carry parameters, such as a charset.
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "application/octet-stream" {
+			var raw []byte
+			raw, err = io.ReadAll(io.LimitReader(r.Body, wire.RAW_TRANSACTION_MAX_SIZE + 1))
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				utils.WriteError(w, utils.DecodeError(err))
+				return
+			}
+			if len(raw) > wire.RAW_TRANSACTION_MAX_SIZE {
+				utils.WriteError(w, utils.NewAPIError(http.StatusRequestEntityTooLarge, utils.ERR_CODE_BODY_TOO_LARGE, "raw transaction too large"))
+				return
+			}
+			t, err = block.TransactionRequestFromRaw(raw)
 		} else {
-			w.WriteHeader(http.StatusCreated)
-			m = utils.JsonStatus("success")
+			var req rawTransactionRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				log.Printf("ERROR: %v", err)
+				utils.WriteError(w, utils.DecodeError(err))
+				return
+			}
+			if req.RawTransaction == nil {
+				utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_MISSING_FIELD, "missing raw_transaction"))
+				return
+			}
+			t, err = block.TransactionRequestFromRawHex(*req.RawTransaction)
 		}
-		io.WriteString(w, string(m))
-
-	case http.MethodPut:
-		decoder := json.NewDecoder(r.Body)
-		var t block.TransactionRequest
-		err := decoder.Decode(&t)
 		if err != nil {
 			log.Printf("ERROR: %v", err)
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+			utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_BAD_RAW_TRANSACTION, err.Error()))
 			return
 		}
-		if !t.Validate() {
-			log.Printf("ERROR: Missing field(s)")
-			io.WriteString(w, string(utils.JsonStatus("fail")))
-			return
-		}
-		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		signature := utils.SignatureFromString(*t.Signature)
-		bc := bcs.GetBlockchain()
-		isUpdated := bc.AddTransaction(
-			*t.SenderAddress,
-			*t.ReceiverAddress,
-			*t.Value,
-			publicKey,
-			signature,
-		)
-
-		w.Header().Add("Content-Type", "application/json")
-		var m []byte
-		if !isUpdated {
-			w.WriteHeader(http.StatusBadRequest)
-			m = utils.JsonStatus("fail")
-		} else {
-			m = utils.JsonStatus("success")
-		}
-		io.WriteString(w, string(m))
-		 
-	case http.MethodDelete:
-		bc := bcs.GetBlockchain()
-		bc.ClearTransactionPool()
-		io.WriteString(w, string(utils.JsonStatus("success")))
-	
+		bcs.submitTransaction(w, t)
 	default:
-		log.Println("ERROR: Invalid HTTP Method")
-		w.WriteHeader(http.StatusBadRequest)
+		utils.MethodNotAllowed(w, http.MethodPost)
+	}
+}
+
+func (bcs *BlockchainServer) MempoolStats(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		m, _ := json.Marshal(bcs.GetBlockchain().Mempool().Stats())
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet)
 	}
 }
 
@@ -154,18 +257,14 @@ func (bcs *BlockchainServer) Mine(w http.ResponseWriter, r *http.Request) {
 		bc := bcs.GetBlockchain()
 		isMined := bc.Mining()
 
-		var m []byte
 		if !isMined {
-			w.WriteHeader(http.StatusBadRequest)
-			m = utils.JsonStatus("fail")
-		} else {
-			m = utils.JsonStatus("success") 
+			utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_NOTHING_TO_MINE, "nothing was mined"))
+			return
 		}
 		w.Header().Add("Content-Type", "application/json")
-		io.WriteString(w, string(m))
+		io.WriteString(w, string(utils.JsonStatus("success")))
 	default:
-		log.Println("ERROR: Invalid HTTP Method")
-		w.WriteHeader(http.StatusBadRequest)
+		utils.MethodNotAllowed(w, http.MethodGet)
 	}
 }
 
@@ -179,8 +278,21 @@ func (bcs *BlockchainServer) StartMine(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
 		io.WriteString(w, string(m))
 	default:
-		log.Println("ERROR: Invalid HTTP Method")
-		w.WriteHeader(http.StatusBadRequest)
+		utils.MethodNotAllowed(w, http.MethodGet)
+	}
+}
+
+func (bcs *BlockchainServer) StopMine(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		bc := bcs.GetBlockchain()
+		bc.StopMining()
+
+		m := utils.JsonStatus("success")
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m))
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet)
 	}
 }
 
@@ -190,24 +302,401 @@ func (bcs *BlockchainServer) Amount(w http.ResponseWriter, r *http.Request) {
 		blockchainAddress := r.URL.Query().Get("blockchain_address")
 		amount := bcs.GetBlockchain().CalculateTotalAmount(blockchainAddress)
 
-		ar := &block.AmountResponse{amount}
+		ar := &block.AmountResponse{Amount: amount}
 		m, _ := ar.MarshalJSON()
 
 		w.Header().Add("Content-Type", "application/json")
 		io.WriteString(w, string(m[:]))
 
-	default: 
-		log.Printf("ERROR: Invalid HTTP Method")
-		w.WriteHeader(http.StatusBadRequest)
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet)
+	}
+}
+
+// AddressTransactions serves /address/{addr}/transactions, newest first,
+// optionally filtered by direction=in|out and paged with offset and limit.
+func (bcs *BlockchainServer) AddressTransactions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		address, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/address/"), "/transactions")
+		if !ok || address == "" || strings.Contains(address, "/") {
+			utils.WriteError(w, utils.NotFound("not found"))
+			return
+		}
+		direction := r.URL.Query().Get("direction")
+		if direction != "" && direction != block.DirectionIn && direction != block.DirectionOut {
+			utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_INVALID_PARAMETER, "invalid direction"))
+			return
+		}
+		offset, err := queryInt(r, "offset", 0)
+		if err != nil || offset < 0 {
+			utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_INVALID_PARAMETER, "invalid offset"))
+			return
+		}
+		limit, err := queryInt(r, "limit", ADDRESS_TRANSACTIONS_LIMIT)
+		if err != nil || limit <= 0 {
+			utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_INVALID_PARAMETER, "invalid limit"))
+			return
+		}
+		if limit > ADDRESS_TRANSACTIONS_MAX_LIMIT {
+			limit = ADDRESS_TRANSACTIONS_MAX_LIMIT
+		}
+		transactions, total := bcs.GetBlockchain().AddressTransactions(address, direction, offset, limit)
+		var next *int
+		if offset + len(transactions) < total {
+			n := offset + len(transactions)
+			next = &n
+		}
+		m, _ := json.Marshal(&addressTransactionsResponse{
+			Address: address,
+			Transactions: transactions,
+			Total: total,
+			Next: next,
+		})
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet)
+	}
+}
+
+// Nonce serves the nonce the next transaction of blockchain_address should
+// use. Resending a pending nonce with a higher fee replaces that transaction.
+func (bcs *BlockchainServer) Nonce(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		blockchainAddress := r.URL.Query().Get("blockchain_address")
+		bc := bcs.GetBlockchain()
+		m, _ := json.Marshal(&nonceResponse{
+			Nonce: bc.PendingNonce(blockchainAddress),
+			ConfirmedNonce: bc.ConfirmedNonce(blockchainAddress),
+		})
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet)
+	}
+}
+
+// AdminPeers lists the known peers. DELETE ?address= forgives a peer,
+// lifting its ban.
+func (bcs *BlockchainServer) AdminPeers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		peers := bcs.peers.Peers()
+		m, _ := json.Marshal(&peersResponse{
+			Peers: peers,
+			Length: len(peers),
+		})
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	case http.MethodDelete:
+		address := r.URL.Query().Get("address")
+		if address == "" {
+			utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_MISSING_FIELD, "missing address"))
+			return
+		}
+		if !bcs.peers.Forgive(address) {
+			utils.WriteError(w, utils.NotFound("peer not found"))
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(utils.JsonStatus("success")))
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+// Shutdown stops mining and the server once this request is answered.
+func (bcs *BlockchainServer) Shutdown(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(utils.JsonStatus("success")))
+		go func() {
+			defer close(bcs.stopped)
+			log.Printf("action=shutdown")
+			bcs.GetBlockchain().StopMining()
+			ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+			defer cancel()
+			if err := bcs.server.Shutdown(ctx); err != nil {
+				log.Printf("ERROR: %v", err)
+			}
+		}()
+	default:
+		utils.MethodNotAllowed(w, http.MethodPost)
+	}
+}
+
+// Limits serves what the rate, body size and concurrency limits have done
+// to each endpoint.
+func (bcs *BlockchainServer) Limits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		// The API and peer routes do not share patterns.
+		stats := bcs.opts.Limiter.Stats()
+		for endpoint, s := range bcs.opts.PeerLimiter.Stats() {
+			stats[endpoint] = s
+		}
+		m, _ := json.Marshal(stats)
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet)
+	}
+}
+
+// queryInt reads an integer query parameter, falling back to def when it is
+// absent.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+func (bcs *BlockchainServer) SyncHeaders(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		from, err := queryInt(r, "from", 0)
+		if err != nil {
+			utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_INVALID_PARAMETER, "invalid from"))
+			return
+		}
+		limit, err := queryInt(r, "limit", block.SYNC_HEADERS_BATCH)
+		if err != nil || limit > block.SYNC_HEADERS_BATCH {
+			limit = block.SYNC_HEADERS_BATCH
+		}
+		bc := bcs.GetBlockchain()
+		m, _ := json.Marshal(&block.HeadersResponse{
+			Headers: bc.Headers(from, limit),
+			Height: bc.Height(),
+		})
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet)
+	}
+}
+
+func (bcs *BlockchainServer) SyncBlocks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		from, err := queryInt(r, "from", 0)
+		if err != nil {
+			utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_INVALID_PARAMETER, "invalid from"))
+			return
+		}
+		to, err := queryInt(r, "to", from + block.SYNC_BLOCKS_BATCH - 1)
+		if err != nil {
+			utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_INVALID_PARAMETER, "invalid to"))
+			return
+		}
+		if to - from >= block.SYNC_BLOCKS_BATCH {
+			to = from + block.SYNC_BLOCKS_BATCH - 1
+		}
+		m, _ := json.Marshal(&block.BlocksResponse{
+			Blocks: bcs.GetBlockchain().Blocks(from, to),
+		})
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet)
+	}
+}
+
+// BlocksPage serves /blocks?from=&to=&limit=, one page of the chain in
+// height order. The response is streamed a batch at a time so large pages
+// never sit in memory, and "next" is the from of the following page. With
+// headers=true only block headers are sent.
+func (bcs *BlockchainServer) BlocksPage(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		bc := bcs.GetBlockchain()
+		height := bc.Height()
+		from, err := queryInt(r, "from", 0)
+		if err != nil || from < 0 {
+			utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_INVALID_PARAMETER, "invalid from"))
+			return
+		}
+		to, err := queryInt(r, "to", from + height)
+		if err != nil || to < from {
+			utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_INVALID_PARAMETER, "invalid to"))
+			return
+		}
+		limit, err := queryInt(r, "limit", BLOCKS_PAGE_LIMIT)
+		if err != nil || limit <= 0 {
+			utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_INVALID_PARAMETER, "invalid limit"))
+			return
+		}
+		if limit > BLOCKS_PAGE_MAX_LIMIT {
+			limit = BLOCKS_PAGE_MAX_LIMIT
+		}
+		if to > height {
+			to = height
+		}
+		last := to
+		if to - from >= limit {
+			to = from + limit - 1
+		}
+		headersOnly := r.URL.Query().Get("headers") == "true"
+
+		w.Header().Add("Content-Type", "application/json")
+		flusher, _ := w.(http.Flusher)
+		io.WriteString(w, `{"blocks":[`)
+		written := 0
+		for start := from; start <= to; start += block.SYNC_BLOCKS_BATCH {
+			end := start + block.SYNC_BLOCKS_BATCH - 1
+			if end > to {
+				end = to
+			}
+			for _, b := range bc.Blocks(start, end) {
+				var m []byte
+				if headersOnly {
+					m, _ = b.Header().MarshalJSON()
+				} else {
+					m, _ = b.MarshalJSON()
+				}
+				if written > 0 {
+					io.WriteString(w, ",")
+				}
+				w.Write(m)
+				written++
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		io.WriteString(w, "]")
+		if from + written <= last {
+			io.WriteString(w, `,"next":`+strconv.Itoa(from + written))
+		}
+		io.WriteString(w, `,"height":`+strconv.Itoa(height)+"}")
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet)
+	}
+}
+
+// Blocks serves /blocks/tip, /blocks/{height} and /blocks/hash/{hash}.
+func (bcs *BlockchainServer) Blocks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		bc := bcs.GetBlockchain()
+		path := strings.TrimPrefix(r.URL.Path, "/blocks/")
+		var b *block.Block
+		switch {
+		case path == "tip":
+			b = bc.Tip()
+		case strings.HasPrefix(path, "hash/"):
+			hash, err := hex.DecodeString(strings.TrimPrefix(path, "hash/"))
+			if err != nil || len(hash) != 32 {
+				utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_INVALID_PARAMETER, "invalid hash"))
+				return
+			}
+			b = bc.BlockByHash([32]byte(hash))
+		default:
+			height, err := strconv.Atoi(path)
+			if err != nil || height < 0 {
+				utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_INVALID_PARAMETER, "invalid height"))
+				return
+			}
+			b = bc.BlockAt(height)
+		}
+		if b == nil {
+			utils.WriteError(w, utils.NotFound("block not found"))
+			return
+		}
+		m, _ := b.MarshalJSON()
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet)
+	}
+}
+
+func (bcs *BlockchainServer) SyncStatus(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		m, _ := json.Marshal(bcs.GetBlockchain().Syncer().Status())
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet)
+	}
+}
+
+func (bcs *BlockchainServer) Inv(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		peer, neighbour, ok := bcs.checkPeer(w, r)
+		if !ok {
+			return
+		}
+		var inv block.InvMessage
+		if err := json.NewDecoder(r.Body).Decode(&inv); err != nil {
+			log.Printf("ERROR: %v", err)
+			bcs.peers.Misbehaving(peer, block.MisbehaviourMalformedJSON)
+			utils.WriteError(w, utils.DecodeError(err))
+			return
+		}
+		// The items are fetched from the announcing node, which must be a
+		// neighbour we know rather than any address the client names.
+		if neighbour == "" {
+			log.Printf("action=inv_ignored, peer=%s", peer)
+		} else if !bcs.GetBlockchain().Gossip().HandleInvAsync(neighbour, &inv) {
+			log.Printf("action=inv_dropped, peer=%s", neighbour)
+		}
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(utils.JsonStatus("success")))
+	default:
+		utils.MethodNotAllowed(w, http.MethodPost)
+	}
+}
+
+func (bcs *BlockchainServer) GetData(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		peer, _, ok := bcs.checkPeer(w, r)
+		if !ok {
+			return
+		}
+		var req block.GetDataRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Printf("ERROR: %v", err)
+			bcs.peers.Misbehaving(peer, block.MisbehaviourMalformedJSON)
+			utils.WriteError(w, utils.DecodeError(err))
+			return
+		}
+		m, _ := json.Marshal(bcs.GetBlockchain().Gossip().GetData(&req))
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, http.MethodPost)
 	}
 }
 
 func (bcs *BlockchainServer) Run() {
+	if bcs.opts.Wire {
+		if err := bcs.GetBlockchain().EnableWire(); err != nil {
+			log.Fatal(err)
+		}
+	}
 	bcs.GetBlockchain().Run()
-	http.HandleFunc("/", bcs.GetChain)
-	http.HandleFunc("/transactions", bcs.Transactions)
-	http.HandleFunc("/mine", bcs.Mine)
-	http.HandleFunc("/mine/start", bcs.StartMine)
-	http.HandleFunc("/amount", bcs.Amount)
-	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(bcs.Port())), nil))
+	if bcs.opts.InitialSync {
+		go bcs.GetBlockchain().Syncer().Run()
+	}
+	bcs.registerMetrics()
+	address := "0.0.0.0:"+strconv.Itoa(int(bcs.Port()))
+	bcs.server = &http.Server{Addr: address, Handler: bcs.NewMux(), TLSConfig: bcs.opts.ServerTLS}
+	var err error
+	if bcs.opts.ServerTLS != nil {
+		err = bcs.server.ListenAndServeTLS("", "")
+	} else {
+		err = bcs.server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-bcs.stopped
 }
\ No newline at end of file
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/palmcivet7/go-blockchain/block"
+	"github.com/palmcivet7/go-blockchain/utils"
+)
+
+type errorMapping struct {
+	err    error
+	status int
+	code   string
+}
+
+// transactionErrors gives the response to each reason a transaction may be
+// refused for.
+var transactionErrors = []errorMapping{
+	{utils.ErrPublicKeyLength, http.StatusBadRequest, utils.ERR_CODE_BAD_PUBLIC_KEY},
+	{utils.ErrPublicKeyHex, http.StatusBadRequest, utils.ERR_CODE_BAD_PUBLIC_KEY},
+	{utils.ErrPublicKeyInvalid, http.StatusBadRequest, utils.ERR_CODE_BAD_PUBLIC_KEY},
+	{utils.ErrSignatureLength, http.StatusBadRequest, utils.ERR_CODE_BAD_SIGNATURE},
+	{utils.ErrSignatureHex, http.StatusBadRequest, utils.ERR_CODE_BAD_SIGNATURE},
+	{utils.ErrSignatureRange, http.StatusBadRequest, utils.ERR_CODE_BAD_SIGNATURE},
+	{utils.ErrSignatureHighS, http.StatusBadRequest, utils.ERR_CODE_BAD_SIGNATURE},
+	{block.ErrInvalidSignature, http.StatusBadRequest, utils.ERR_CODE_BAD_SIGNATURE},
+	{block.ErrMiningSender, http.StatusBadRequest, utils.ERR_CODE_INVALID_SENDER},
+	{block.ErrInvalidValue, http.StatusBadRequest, utils.ERR_CODE_INVALID_VALUE},
+	{block.ErrInvalidFee, http.StatusBadRequest, utils.ERR_CODE_INVALID_FEE},
+	{block.ErrInsufficientFunds, http.StatusUnprocessableEntity, utils.ERR_CODE_INSUFFICIENT_FUNDS},
+	{block.ErrNonceTooLow, http.StatusConflict, utils.ERR_CODE_NONCE_TOO_LOW},
+	{block.ErrMempoolDuplicate, http.StatusConflict, utils.ERR_CODE_DUPLICATE_TRANSACTION},
+	{block.ErrReplacementUnderpriced, http.StatusConflict, utils.ERR_CODE_REPLACEMENT_UNDERPRICED},
+	{block.ErrMempoolSenderLimit, http.StatusUnprocessableEntity, utils.ERR_CODE_SENDER_LIMIT},
+	{block.ErrMempoolFull, http.StatusServiceUnavailable, utils.ERR_CODE_MEMPOOL_FULL},
+	{block.ErrMempoolTooLarge, http.StatusRequestEntityTooLarge, utils.ERR_CODE_TRANSACTION_TOO_LARGE},
+}
+
+// transactionError turns the reason a transaction was refused into an API
+// error. Anything unrecognised is the node's fault, not the client's.
+func transactionError(err error) *utils.APIError {
+	for _, m := range transactionErrors {
+		if errors.Is(err, m.err) {
+			return utils.NewAPIError(m.status, m.code, err.Error())
+		}
+	}
+	return utils.NewAPIError(http.StatusInternalServerError, utils.ERR_CODE_INTERNAL_ERROR, err.Error())
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/palmcivet7/go-blockchain/block"
+	"github.com/palmcivet7/go-blockchain/utils"
+)
+
+func TestTransactionError(t *testing.T) {
+	for _, m := range transactionErrors {
+		if m.status < 400 || m.status >= 600 {
+			t.Errorf("%v maps to status %d", m.err, m.status)
+		}
+		e := transactionError(fmt.Errorf("submit: %w", m.err))
+		if e.Status != m.status || e.Code != m.code {
+			t.Errorf("wrapped %v is %d %s, want %d %s", m.err, e.Status, e.Code, m.status, m.code)
+		}
+	}
+
+	e := transactionError(block.ErrInsufficientFunds)
+	if e.Status != http.StatusUnprocessableEntity || e.Code != utils.ERR_CODE_INSUFFICIENT_FUNDS {
+		t.Errorf("insufficient funds is %d %s", e.Status, e.Code)
+	}
+	e = transactionError(errors.New("disk full"))
+	if e.Status != http.StatusInternalServerError || e.Code != utils.ERR_CODE_INTERNAL_ERROR {
+		t.Errorf("an unknown error is %d %s, want %d %s", e.Status, e.Code, http.StatusInternalServerError, utils.ERR_CODE_INTERNAL_ERROR)
+	}
+}
@@ -1,8 +1,16 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
+	"strings"
+	"time"
+
+	"github.com/palmcivet7/go-blockchain/block"
+	"github.com/palmcivet7/go-blockchain/utils"
 )
 
 func init() {
@@ -10,8 +18,95 @@ func init() {
 }
 
 func main() {
-	port := flag.Uint("port", 5000, "TCP Port Number for Blockchain Server")
+	network := flag.String("network", block.MainnetParams.Name, fmt.Sprintf("Network to join, one of %v", block.NetworkNames()))
+	genesis := flag.String("genesis", "", "Genesis spec file with the timestamp, difficulty, extra data and premine of block 0")
+	port := flag.Uint("port", 0, "TCP Port Number for Blockchain Server, defaults to the network's port")
+	banThreshold := flag.Int("ban_threshold", block.PEER_BAN_THRESHOLD, "Misbehaviour score at which a peer is banned")
+	banDuration := flag.Duration("ban_duration", time.Second * block.PEER_BAN_DURATION_SEC, "How long a misbehaving peer stays banned")
+	mempoolMaxCount := flag.Int("mempool_max_count", block.MEMPOOL_MAX_COUNT, "Most transactions kept pending")
+	mempoolMaxBytes := flag.Int("mempool_max_bytes", block.MEMPOOL_MAX_BYTES, "Most bytes of transactions kept pending")
+	mempoolMaxPerSender := flag.Int("mempool_max_per_sender", block.MEMPOOL_MAX_PER_SENDER, "Most transactions one sender may have pending")
+	mempoolExpiry := flag.Duration("mempool_expiry", time.Second * block.MEMPOOL_EXPIRY_SEC, "How long a transaction may stay pending")
+	initialSync := flag.Bool("initial_sync", true, "Download the chain from neighbours on startup")
+	wire := flag.Bool("wire", false, fmt.Sprintf("Also talk to neighbours over the binary TCP protocol on port + %d", block.WIRE_PORT_OFFSET))
+	useTLS := flag.Bool("tls", false, "Serve and dial neighbours over TLS")
+	nodeKey := flag.String("node_key", "node.key", "Node private key, created on first run")
+	nodeCert := flag.String("node_cert", "node.crt", "Node certificate, created from the node key on first run")
+	adminTokenFile := flag.String("admin_token_file", "admin.token", "Bearer token for admin requests, created on first run")
+	rateLimit := flag.Float64("rate_limit", utils.RATE_LIMIT_PER_SEC, "API requests a second allowed from one IP, 0 for no limit")
+	rateBurst := flag.Int("rate_burst", utils.RATE_LIMIT_BURST, "API requests one IP may make at once before -rate_limit applies")
+	peerRateLimit := flag.Float64("peer_rate_limit", utils.PEER_RATE_LIMIT_PER_SEC, "Peer protocol requests a second allowed from one IP, 0 for no limit")
+	peerRateBurst := flag.Int("peer_rate_burst", utils.PEER_RATE_LIMIT_BURST, "Peer protocol requests one IP may make at once before -peer_rate_limit applies")
+	maxBodyBytes := flag.Int64("max_body_bytes", utils.MAX_BODY_BYTES, "Largest API request body accepted, 0 for no limit")
+	trustedCerts := flag.String("trusted_certs", "", "Certificate file or directory of *.crt files; enables mutual TLS with only these nodes")
+	nodeIDs := flag.String("node_ids", "", "Comma separated address=node_id pairs; over TLS the neighbours at these addresses must present these node IDs rather than being trusted on first contact")
+	wsOrigins := flag.String("ws_origins", "", "Comma separated origins besides the node's own whose pages may open a WebSocket, * for any")
+	openAPI := flag.Bool("openapi", false, "Print the OpenAPI document of the REST API and exit")
 	flag.Parse()
-	app := NewBlockchainServer(uint16(*port))
+
+	if *openAPI {
+		m, _ := json.MarshalIndent(NewBlockchainServer(0, nil, nil).OpenAPIDocument(), "", "  ")
+		fmt.Println(string(m))
+		return
+	}
+
+	params, err := block.ParamsForNetwork(*network)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *genesis != "" {
+		spec, err := block.LoadGenesisSpec(*genesis)
+		if err != nil {
+			log.Fatal(err)
+		}
+		params = params.WithGenesis(spec)
+	}
+	if *port == 0 {
+		*port = uint(params.DefaultPort)
+	}
+	log.Printf("network %s, chain_id %d, genesis %x", params.Name, params.ChainID, params.Genesis().Hash())
+
+	mempool := block.NewMempool(*mempoolMaxCount, *mempoolMaxBytes, *mempoolMaxPerSender, *mempoolExpiry)
+	adminToken, err := utils.LoadOrCreateAdminToken(*adminTokenFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("admin_token_file %s", *adminTokenFile)
+	opts := &Options{Params: params, Mempool: mempool, InitialSync: *initialSync, Wire: *wire, AdminToken: adminToken,
+		Limiter: utils.NewLimiter(*rateLimit, *rateBurst, *maxBodyBytes),
+		PeerLimiter: utils.NewLimiter(*peerRateLimit, *peerRateBurst, *maxBodyBytes)}
+	for _, origin := range strings.Split(*wsOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			opts.WSOrigins = append(opts.WSOrigins, origin)
+		}
+	}
+	if *useTLS {
+		cert, err := utils.LoadOrCreateNodeIdentity(*nodeKey, *nodeCert)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var trusted map[string]bool
+		if *trustedCerts != "" {
+			trusted, err = utils.LoadTrustedCertificates(*trustedCerts)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		key := cert.PrivateKey.(*ecdsa.PrivateKey)
+		log.Printf("node_id %s", utils.NodeID(&key.PublicKey))
+		log.Printf("node_cert_fingerprint %s", utils.Fingerprint(cert.Certificate[0]))
+		opts.ServerTLS = utils.NodeServerTLSConfig(cert, trusted)
+		opts.ClientTLS = utils.NodeClientTLSConfig(&cert, trusted)
+	}
+
+	peers := block.NewPeerManager(*banThreshold, *banDuration)
+	pinned, err := utils.ParseNodeIDs(*nodeIDs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for address, id := range pinned {
+		peers.PinIdentity(address, id)
+	}
+	app := NewBlockchainServer(uint16(*port), peers, opts)
 	app.Run()
 }
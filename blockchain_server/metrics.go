@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/palmcivet7/go-blockchain/block"
+)
+
+// METRICS_EVENT_BUFFER is large so that counting never falls behind the
+// events it counts.
+const METRICS_EVENT_BUFFER = 4096
+
+// registerMetrics fills the node's registry. Gauges are read from the chain
+// at every scrape, counters follow its events.
+func (bcs *BlockchainServer) registerMetrics() {
+	r := bcs.metrics
+	bc := bcs.GetBlockchain()
+
+	r.NewGaugeFunc("blockchain_height", "Height of the chain tip.", func() float64 {
+		return float64(bc.Height())
+	})
+	r.NewGaugeFunc("blockchain_mempool_transactions", "Transactions waiting in the mempool.", func() float64 {
+		return float64(bc.Mempool().Len())
+	})
+	r.NewGaugeFunc("blockchain_mempool_bytes", "Size of the transactions waiting in the mempool.", func() float64 {
+		return float64(bc.Mempool().Stats().Bytes)
+	})
+	r.NewGaugeFunc("blockchain_hash_rate", "Hashes a second of the last proof of work.", bc.HashRate)
+	r.NewCounterFunc("blockchain_hashes_total", "Hashes tried by proofs of work.", func() float64 {
+		return float64(bc.Hashes())
+	})
+	mined := r.NewCounter("blockchain_blocks_mined_total", "Blocks mined by this node.")
+	accepted := r.NewCounter("blockchain_transactions_accepted_total", "Transactions admitted to the mempool.")
+	rejected := r.NewCounter("blockchain_transactions_rejected_total", "Transactions refused, by the error code the API answers with.", "reason")
+	r.NewGaugeFunc("blockchain_peers", "Neighbours the node exchanges transactions and blocks with.", func() float64 {
+		return float64(len(bc.Neighbours()))
+	})
+	r.NewGaugeFunc("blockchain_banned_peers", "Peers banned for misbehaving.", func() float64 {
+		banned := 0
+		now := time.Now()
+		for _, p := range bcs.peers.Peers() {
+			if p.IsBanned(now) {
+				banned++
+			}
+		}
+		return float64(banned)
+	})
+	r.NewGaugeFunc("blockchain_sync_lag_blocks", "Blocks between the tip and the best height known from neighbours.", func() float64 {
+		best, height := bc.Syncer().BestPeerHeight(), bc.Height()
+		if best <= height {
+			return 0
+		}
+		return float64(best - height)
+	})
+	bcs.opts.Limiter.RegisterMetrics(r, "http_limiter")
+	bcs.opts.PeerLimiter.RegisterMetrics(r, "peer_limiter")
+
+	sub := bc.Events().Subscribe(METRICS_EVENT_BUFFER, block.EventNewTransaction, block.EventTransactionRejected, block.EventBlockMined)
+	r.NewCounterFunc("blockchain_metrics_events_dropped_total", "Events missed by the counters above.", func() float64 {
+		return float64(sub.Dropped())
+	})
+	go func() {
+		for e := range sub.Events() {
+			switch e.Type {
+			case block.EventNewTransaction:
+				accepted.Inc()
+			case block.EventTransactionRejected:
+				rejected.Inc(strings.ToLower(transactionError(e.Err).Code))
+			case block.EventBlockMined:
+				mined.Inc()
+			}
+		}
+	}()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/palmcivet7/go-blockchain/utils"
+)
+
+var (
+	metricCommentPattern = regexp.MustCompile(`^# (HELP|TYPE) ([a-zA-Z_:][a-zA-Z0-9_:]*) (.+)$`)
+	metricSamplePattern  = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{([a-zA-Z_][a-zA-Z0-9_]*="(\\\\|\\"|\\n|[^"\\])*",?)*\})? (\S+)$`)
+)
+
+// scrapeMetrics checks that the exposition is well formed, and returns its
+// samples keyed by name and labels.
+func scrapeMetrics(t *testing.T, url string) map[string]float64 {
+	t.Helper()
+	resp, err := http.Get(url + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != utils.METRICS_CONTENT_TYPE {
+		t.Fatalf("content type is %q, want %q", ct, utils.METRICS_CONTENT_TYPE)
+	}
+
+	types := make(map[string]string)
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := metricCommentPattern.FindStringSubmatch(line); m != nil {
+			if m[1] == "TYPE" {
+				if _, ok := types[m[2]]; ok {
+					t.Errorf("%s is declared twice", m[2])
+				}
+				types[m[2]] = m[3]
+			}
+			continue
+		}
+		m := metricSamplePattern.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("malformed line %q", line)
+			continue
+		}
+		family := m[1]
+		if _, ok := types[family]; !ok {
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				family = strings.TrimSuffix(family, suffix)
+			}
+			if types[family] != "histogram" {
+				t.Errorf("sample %q comes before the TYPE of its metric", line)
+			}
+		}
+		value, err := strconv.ParseFloat(m[len(m)-1], 64)
+		if err != nil {
+			t.Errorf("sample %q has a malformed value", line)
+		}
+		samples[m[1]+m[2]] = value
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	for name, kind := range types {
+		switch kind {
+		case "counter", "gauge", "histogram":
+		default:
+			t.Errorf("%s has type %q", name, kind)
+		}
+	}
+	return samples
+}
+
+func TestMetricsExposition(t *testing.T) {
+	_, srv := newTestServer(t)
+	if _, err := http.Get(srv.URL + utils.API_V1_PREFIX + "/amount?blockchain_address=nobody"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get(srv.URL + "/sync/headers?limit=0"); err != nil {
+		t.Fatal(err)
+	}
+
+	samples := scrapeMetrics(t, srv.URL)
+	want := map[string]float64{
+		"blockchain_height":                                    0,
+		"blockchain_sync_lag_blocks":                           0,
+		"blockchain_mempool_transactions":                      0,
+		`http_limiter_allowed_total{endpoint="/amount"}`:       1,
+		`peer_limiter_allowed_total{endpoint="/sync/headers"}`: 1,
+	}
+	for name, value := range want {
+		got, ok := samples[name]
+		if !ok {
+			t.Errorf("%s is missing", name)
+		} else if got != value {
+			t.Errorf("%s is %v, want %v", name, got, value)
+		}
+	}
+	if samples[`http_request_duration_seconds_count{endpoint="/amount",method="GET",code="200"}`] != 1 {
+		t.Errorf("the /amount request was not timed")
+	}
+}
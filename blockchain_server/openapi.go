@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/palmcivet7/go-blockchain/block"
+	"github.com/palmcivet7/go-blockchain/utils"
+)
+
+const OPENAPI_VERSION = "3.0.3"
+
+// The block types below marshal themselves from unexported fields. These
+// structs have the shape their MarshalJSON produces and must be kept in
+// step with it, which TestJSONShapes checks.
+
+type blockJSON struct {
+	Height       int                  `json:"height"`
+	Hash         string               `json:"hash"`
+	Timestamp    int64                `json:"timestamp"`
+	Nonce        int                  `json:"nonce"`
+	PreviousHash string               `json:"previous_hash"`
+	Transactions []*block.Transaction `json:"transactions"`
+	ExtraData    string               `json:"extra_data,omitempty"`
+}
+
+type blockHeaderJSON struct {
+	Height           int    `json:"height"`
+	Timestamp        int64  `json:"timestamp"`
+	Nonce            int    `json:"nonce"`
+	PreviousHash     string `json:"previous_hash"`
+	TransactionsHash string `json:"transactions_hash"`
+	ExtraData        string `json:"extra_data,omitempty"`
+}
+
+type blockchainJSON struct {
+	Blocks []*block.Block `json:"chains"`
+}
+
+type transactionJSON struct {
+	Sender   string  `json:"sender_address"`
+	Receiver string  `json:"receiver_address"`
+	Value    float64 `json:"value"`
+	Fee      float64 `json:"fee,omitempty"`
+	Nonce    uint64  `json:"nonce"`
+}
+
+type addressTransactionJSON struct {
+	Hash            string  `json:"hash"`
+	SenderAddress   string  `json:"sender_address"`
+	ReceiverAddress string  `json:"receiver_address"`
+	Value           float64 `json:"value"`
+	Fee             float64 `json:"fee"`
+	Nonce           uint64  `json:"nonce"`
+	Direction       string  `json:"direction"`
+	BlockHeight     int     `json:"block_height"`
+	BlockHash       string  `json:"block_hash"`
+	Timestamp       int64   `json:"timestamp"`
+	Confirmations   int     `json:"confirmations"`
+}
+
+type peerJSON struct {
+	Address       string         `json:"address"`
+	Score         int            `json:"score"`
+	Misbehaviours map[string]int `json:"misbehaviours"`
+	LastSeen      int64          `json:"last_seen"`
+	Banned        bool           `json:"banned"`
+	BannedUntil   int64          `json:"banned_until"`
+	NodeID        string         `json:"node_id,omitempty"`
+}
+
+var jsonShapes = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(block.Block{}):              reflect.TypeOf(blockJSON{}),
+	reflect.TypeOf(block.BlockHeader{}):        reflect.TypeOf(blockHeaderJSON{}),
+	reflect.TypeOf(block.Blockchain{}):         reflect.TypeOf(blockchainJSON{}),
+	reflect.TypeOf(block.Transaction{}):        reflect.TypeOf(transactionJSON{}),
+	reflect.TypeOf(block.AddressTransaction{}): reflect.TypeOf(addressTransactionJSON{}),
+	reflect.TypeOf(block.Peer{}):               reflect.TypeOf(peerJSON{}),
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// openAPISchemas builds JSON schemas from Go types by their json tags. Named
+// structs become components referenced by name.
+type openAPISchemas struct {
+	components map[string]interface{}
+}
+
+func schemaName(t reflect.Type) string {
+	return strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+}
+
+func (s *openAPISchemas) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == rawMessageType {
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return s.ref(t)
+	}
+	return map[string]interface{}{}
+}
+
+func (s *openAPISchemas) ref(t reflect.Type) map[string]interface{} {
+	name := schemaName(t)
+	if _, ok := s.components[name]; !ok {
+		// Claim the name first so a type that contains itself terminates.
+		s.components[name] = nil
+		shape, ok := jsonShapes[t]
+		if !ok {
+			shape = t
+		}
+		s.components[name] = s.object(shape)
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func (s *openAPISchemas) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = s.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (s *openAPISchemas) content(v interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": s.schema(reflect.TypeOf(v))},
+	}
+}
+
+func (s *openAPISchemas) operation(o apiOperation) map[string]interface{} {
+	op := map[string]interface{}{"summary": o.summary}
+	if o.description != "" {
+		op["description"] = o.description
+	}
+	if len(o.params) > 0 {
+		params := make([]interface{}, len(o.params))
+		for i, p := range o.params {
+			param := map[string]interface{}{
+				"name":     p.name,
+				"in":       p.in,
+				"required": p.required,
+				"schema":   map[string]interface{}{"type": p.kind},
+			}
+			if p.description != "" {
+				param["description"] = p.description
+			}
+			params[i] = param
+		}
+		op["parameters"] = params
+	}
+	if o.request != nil {
+		content := s.content(o.request)
+		if o.binary {
+			content["application/octet-stream"] = map[string]interface{}{
+				"schema": map[string]interface{}{"type": "string", "format": "binary"},
+			}
+		}
+		op["requestBody"] = map[string]interface{}{"required": true, "content": content}
+	}
+	status := o.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := map[string]interface{}{"description": http.StatusText(status)}
+	if o.response != nil {
+		response["content"] = s.content(o.response)
+	}
+	responses := map[string]interface{}{
+		strconv.Itoa(status): response,
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content":     s.content(&utils.APIError{}),
+		},
+	}
+	if o.admin {
+		op["security"] = []interface{}{map[string]interface{}{"adminToken": []string{}}}
+		responses[strconv.Itoa(http.StatusUnauthorized)] = map[string]interface{}{
+			"description": "The admin token is missing or wrong",
+			"content":     s.content(&utils.APIError{}),
+		}
+	}
+	op["responses"] = responses
+	return op
+}
+
+func (s *openAPISchemas) addPaths(paths map[string]interface{}, routes []apiRoute, servers []interface{}) {
+	for _, route := range routes {
+		for _, o := range route.operations {
+			path := o.path
+			if path == "" {
+				path = route.pattern
+			}
+			item, ok := paths[path].(map[string]interface{})
+			if !ok {
+				item = map[string]interface{}{}
+				if servers != nil {
+					item["servers"] = servers
+				}
+				paths[path] = item
+			}
+			item[strings.ToLower(o.method)] = s.operation(o)
+		}
+	}
+}
+
+// OpenAPIDocument describes the REST API, the peer protocol and the
+// metrics endpoint, generated from the route tables and the types they
+// send and receive.
+func (bcs *BlockchainServer) OpenAPIDocument() map[string]interface{} {
+	s := &openAPISchemas{components: map[string]interface{}{}}
+	paths := map[string]interface{}{}
+	s.addPaths(paths, bcs.apiRoutes(), nil)
+	s.addPaths(paths, bcs.peerRoutes(), []interface{}{
+		map[string]interface{}{"url": "/", "description": "Node-to-node protocol, not versioned"},
+	})
+	s.addPaths(paths, bcs.rootRoutes(), []interface{}{
+		map[string]interface{}{"url": "/", "description": "Not versioned"},
+	})
+	s.schema(reflect.TypeOf(wsRequest{}))
+	s.schema(reflect.TypeOf(wsMessage{}))
+	return map[string]interface{}{
+		"openapi": OPENAPI_VERSION,
+		"info": map[string]interface{}{
+			"title":       "go-blockchain node",
+			"version":     "1",
+			"description": "The unversioned paths of earlier releases are still served as deprecated aliases of these.",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": utils.API_V1_PREFIX}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": s.components,
+			"securitySchemes": map[string]interface{}{
+				"adminToken": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "The node's admin token, from its -admin_token_file",
+				},
+			},
+		},
+	}
+}
+
+func (bcs *BlockchainServer) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		m, _ := json.MarshalIndent(bcs.OpenAPIDocument(), "", "  ")
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m))
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet)
+	}
+}
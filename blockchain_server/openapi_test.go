@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/palmcivet7/go-blockchain/block"
+)
+
+// TestJSONShapes checks that the schemas published for the types in
+// jsonShapes have the keys their MarshalJSON produces.
+func TestJSONShapes(t *testing.T) {
+	params := *block.DevnetParams
+	params.GenesisExtraData = []byte("shapes")
+	params.GenesisAllocations = map[string]float64{"alice": 10}
+	peers := block.NewPeerManager(block.PEER_BAN_THRESHOLD, time.Hour)
+	mempool := block.NewMempool(block.MEMPOOL_MAX_COUNT, block.MEMPOOL_MAX_BYTES, block.MEMPOOL_MAX_PER_SENDER, time.Hour)
+	bc := block.NewBlockchain("miner", 0, peers, mempool, &params)
+	genesis := bc.LastBlock()
+	history, _ := bc.AddressTransactions("alice", "", 0, 1)
+	peers.Misbehaving("127.0.0.1:5000", block.MisbehaviourMalformedJSON)
+
+	values := []interface{}{
+		bc,
+		genesis,
+		genesis.Header(),
+		block.NewTransaction("alice", "bob", 1, 0.5, 0),
+		history[0],
+		peers.Peers()[0],
+	}
+	if len(values) != len(jsonShapes) {
+		t.Fatalf("jsonShapes has %d types, the test checks %d", len(jsonShapes), len(values))
+	}
+	for _, v := range values {
+		typ := reflect.TypeOf(v).Elem()
+		s := &openAPISchemas{components: map[string]interface{}{}}
+		s.schema(typ)
+		schema := s.components[schemaName(typ)].(map[string]interface{})
+
+		m, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys map[string]json.RawMessage
+		if err := json.Unmarshal(m, &keys); err != nil {
+			t.Fatal(err)
+		}
+		properties := schema["properties"].(map[string]interface{})
+		for key := range keys {
+			if _, ok := properties[key]; !ok {
+				t.Errorf("%s marshals %q, which its schema lacks", typ.Name(), key)
+			}
+		}
+		required, _ := schema["required"].([]string)
+		for _, key := range required {
+			if _, ok := keys[key]; !ok {
+				t.Errorf("%s schema requires %q, which it does not marshal", typ.Name(), key)
+			}
+		}
+	}
+}
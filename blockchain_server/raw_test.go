@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/palmcivet7/go-blockchain/utils"
+	"github.com/palmcivet7/go-blockchain/wire"
+)
+
+func TestRawTransactionContentType(t *testing.T) {
+	_, srv := newTestServer(t)
+	url := srv.URL + utils.API_V1_PREFIX + "/transactions/raw"
+
+	// Bytes that are neither JSON nor a transaction tell the two decoders
+	// apart by the error they give.
+	for contentType, code := range map[string]string{
+		"application/octet-stream":                 utils.ERR_CODE_BAD_RAW_TRANSACTION,
+		"application/octet-stream; charset=binary": utils.ERR_CODE_BAD_RAW_TRANSACTION,
+		"Application/Octet-Stream":                 utils.ERR_CODE_BAD_RAW_TRANSACTION,
+		"application/json":                         utils.ERR_CODE_MALFORMED_JSON,
+		"":                                         utils.ERR_CODE_MALFORMED_JSON,
+	} {
+		resp, err := http.Post(url, contentType, bytes.NewReader([]byte{0xff, 0x00, 0x01}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e utils.APIError
+		err = json.NewDecoder(resp.Body).Decode(&e)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusBadRequest || e.Code != code {
+			t.Errorf("Content-Type %q: %d %s, want %d %s", contentType, resp.StatusCode, e.Code, http.StatusBadRequest, code)
+		}
+	}
+}
+
+func TestRawTransactionBodyLimit(t *testing.T) {
+	_, srv := newTestServer(t)
+	url := srv.URL + utils.API_V1_PREFIX + "/transactions/raw"
+	for size, status := range map[int]int{
+		wire.RAW_TRANSACTION_MAX_SIZE:     http.StatusBadRequest,
+		wire.RAW_TRANSACTION_MAX_SIZE + 1: http.StatusRequestEntityTooLarge,
+	} {
+		resp, err := http.Post(url, "application/octet-stream", bytes.NewReader(make([]byte, size)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%d bytes: %s, want %d", size, resp.Status, status)
+		}
+	}
+}
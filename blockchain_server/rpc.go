@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/palmcivet7/go-blockchain/block"
+	"github.com/palmcivet7/go-blockchain/utils"
+)
+
+const (
+	RPC_MAX_BATCH = 100
+
+	RPC_PARSE_ERROR      = -32700
+	RPC_INVALID_REQUEST  = -32600
+	RPC_METHOD_NOT_FOUND = -32601
+	RPC_INVALID_PARAMS   = -32602
+	RPC_INTERNAL_ERROR   = -32603
+	// Implementation defined server errors.
+	RPC_NOT_FOUND            = -32001
+	RPC_TRANSACTION_REJECTED = -32002
+	RPC_MINING_FAILED        = -32003
+	RPC_UNAUTHORIZED         = -32004
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+func invalidParams(message string) *rpcError {
+	return &rpcError{Code: RPC_INVALID_PARAMS, Message: message}
+}
+
+// parseParams decodes params given either by position, in the order of
+// names, or by name into v.
+func parseParams(raw json.RawMessage, names []string, v interface{}) *rpcError {
+	if len(raw) == 0 || string(raw) == "null" {
+		raw = []byte("{}")
+	}
+	if raw[0] == '[' {
+		var positional []json.RawMessage
+		if err := json.Unmarshal(raw, &positional); err != nil {
+			return invalidParams(err.Error())
+		}
+		if len(positional) > len(names) {
+			return invalidParams("too many params")
+		}
+		named := make(map[string]json.RawMessage, len(positional))
+		for i, p := range positional {
+			named[names[i]] = p
+		}
+		raw, _ = json.Marshal(named)
+	}
+	if raw[0] != '{' {
+		return invalidParams("params must be an array or an object")
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return invalidParams(err.Error())
+	}
+	return nil
+}
+
+func parseHash(s string) ([32]byte, *rpcError) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		return [32]byte{}, invalidParams("invalid hash")
+	}
+	return [32]byte(b), nil
+}
+
+type rpcMethod func(bcs *BlockchainServer, params json.RawMessage) (interface{}, *rpcError)
+
+var rpcMethods = map[string]rpcMethod{
+	"getBlockCount":      rpcGetBlockCount,
+	"getBlockByHeight":   rpcGetBlockByHeight,
+	"getBlockByHash":     rpcGetBlockByHash,
+	"getTransaction":     rpcGetTransaction,
+	"getBalance":         rpcGetBalance,
+	"getNonce":           rpcGetNonce,
+	"sendRawTransaction": rpcSendRawTransaction,
+	"getMempool":         rpcGetMempool,
+	"getPeers":           rpcGetPeers,
+	"getSyncStatus":      rpcGetSyncStatus,
+	"mine":               rpcMine,
+	"startMining":        rpcStartMining,
+	"stopMining":         rpcStopMining,
+	"getMiningStatus":    rpcGetMiningStatus,
+}
+
+// rpcAdminMethods require the admin token as a bearer token on the request.
+// getPeers shows the same scores and bans as GET /admin/peers.
+var rpcAdminMethods = map[string]bool{
+	"getPeers":    true,
+	"mine":        true,
+	"startMining": true,
+	"stopMining":  true,
+}
+
+func rpcGetBlockCount(bcs *BlockchainServer, params json.RawMessage) (interface{}, *rpcError) {
+	return bcs.GetBlockchain().Height() + 1, nil
+}
+
+func rpcGetBlockByHeight(bcs *BlockchainServer, params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Height *int `json:"height"`
+	}
+	if err := parseParams(params, []string{"height"}, &p); err != nil {
+		return nil, err
+	}
+	if p.Height == nil || *p.Height < 0 {
+		return nil, invalidParams("invalid height")
+	}
+	b := bcs.GetBlockchain().BlockAt(*p.Height)
+	if b == nil {
+		return nil, &rpcError{Code: RPC_NOT_FOUND, Message: "block not found"}
+	}
+	return b, nil
+}
+
+func rpcGetBlockByHash(bcs *BlockchainServer, params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Hash string `json:"hash"`
+	}
+	if err := parseParams(params, []string{"hash"}, &p); err != nil {
+		return nil, err
+	}
+	hash, err := parseHash(p.Hash)
+	if err != nil {
+		return nil, err
+	}
+	b := bcs.GetBlockchain().BlockByHash(hash)
+	if b == nil {
+		return nil, &rpcError{Code: RPC_NOT_FOUND, Message: "block not found"}
+	}
+	return b, nil
+}
+
+func rpcGetTransaction(bcs *BlockchainServer, params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Hash string `json:"hash"`
+	}
+	if err := parseParams(params, []string{"hash"}, &p); err != nil {
+		return nil, err
+	}
+	hash, err := parseHash(p.Hash)
+	if err != nil {
+		return nil, err
+	}
+	type result struct {
+		Hash          string             `json:"hash"`
+		Status        string             `json:"status"`
+		Transaction   *block.Transaction `json:"transaction"`
+		BlockHeight   *int               `json:"block_height,omitempty"`
+		BlockHash     string             `json:"block_hash,omitempty"`
+		Confirmations int                `json:"confirmations"`
+	}
+	bc := bcs.GetBlockchain()
+	if t := bc.PendingTransaction(hash); t != nil {
+		return &result{Hash: p.Hash, Status: "pending", Transaction: t}, nil
+	}
+	t, b := bc.ConfirmedTransaction(hash)
+	if t == nil {
+		return nil, &rpcError{Code: RPC_NOT_FOUND, Message: "transaction not found"}
+	}
+	height := b.Height()
+	blockHash := b.Hash()
+	return &result{
+		Hash:          p.Hash,
+		Status:        "confirmed",
+		Transaction:   t,
+		BlockHeight:   &height,
+		BlockHash:     hex.EncodeToString(blockHash[:]),
+		Confirmations: bc.Height() - height + 1,
+	}, nil
+}
+
+func rpcGetBalance(bcs *BlockchainServer, params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Address string `json:"address"`
+	}
+	if err := parseParams(params, []string{"address"}, &p); err != nil {
+		return nil, err
+	}
+	if p.Address == "" {
+		return nil, invalidParams("missing address")
+	}
+	return &block.AmountResponse{Amount: bcs.GetBlockchain().CalculateTotalAmount(p.Address)}, nil
+}
+
+func rpcGetNonce(bcs *BlockchainServer, params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Address string `json:"address"`
+	}
+	if err := parseParams(params, []string{"address"}, &p); err != nil {
+		return nil, err
+	}
+	if p.Address == "" {
+		return nil, invalidParams("missing address")
+	}
+	return bcs.GetBlockchain().PendingNonce(p.Address), nil
+}
+
+// rpcSendRawTransaction submits a signed transaction, either hex in the raw
+// transaction format or an object as accepted by POST /transactions, and
+// returns its hash.
+func rpcSendRawTransaction(bcs *BlockchainServer, params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Transaction json.RawMessage `json:"transaction"`
+	}
+	if err := parseParams(params, []string{"transaction"}, &p); err != nil {
+		return nil, err
+	}
+	var t *block.TransactionRequest
+	var raw string
+	if err := json.Unmarshal(p.Transaction, &raw); err == nil {
+		if t, err = block.TransactionRequestFromRawHex(raw); err != nil {
+			return nil, invalidParams(err.Error())
+		}
+	} else if err := json.Unmarshal(p.Transaction, &t); err != nil {
+		return nil, invalidParams("transaction must be a hex string or an object")
+	}
+	if t == nil || !t.Validate() {
+		return nil, invalidParams("missing field(s)")
+	}
+	publicKey, signature, err := t.Keys()
+	if err != nil {
+		return nil, &rpcError{Code: RPC_INVALID_PARAMS, Message: err.Error(), Data: transactionError(err)}
+	}
+	err = bcs.GetBlockchain().CreateTransaction(
+		*t.SenderAddress, *t.ReceiverAddress, *t.Value, t.FeeValue(), t.NonceValue(), publicKey, signature)
+	if err != nil {
+		return nil, &rpcError{Code: RPC_TRANSACTION_REJECTED, Message: err.Error(), Data: transactionError(err)}
+	}
+	hash := block.NewTransaction(*t.SenderAddress, *t.ReceiverAddress, *t.Value, t.FeeValue(), t.NonceValue()).Hash()
+	return hex.EncodeToString(hash[:]), nil
+}
+
+func rpcGetMempool(bcs *BlockchainServer, params json.RawMessage) (interface{}, *rpcError) {
+	bc := bcs.GetBlockchain()
+	return struct {
+		Transactions []*block.Transaction `json:"transactions"`
+		Stats        *block.MempoolStats  `json:"stats"`
+	}{
+		Transactions: bc.TransactionPool(),
+		Stats:        bc.Mempool().Stats(),
+	}, nil
+}
+
+func rpcGetPeers(bcs *BlockchainServer, params json.RawMessage) (interface{}, *rpcError) {
+	return struct {
+		Neighbours []string      `json:"neighbours"`
+		Peers      []*block.Peer `json:"peers"`
+	}{
+		Neighbours: bcs.GetBlockchain().Neighbours(),
+		Peers:      bcs.peers.Peers(),
+	}, nil
+}
+
+func rpcGetSyncStatus(bcs *BlockchainServer, params json.RawMessage) (interface{}, *rpcError) {
+	return bcs.GetBlockchain().Syncer().Status(), nil
+}
+
+func rpcMine(bcs *BlockchainServer, params json.RawMessage) (interface{}, *rpcError) {
+	bc := bcs.GetBlockchain()
+	if !bc.Mining() {
+		return nil, &rpcError{Code: RPC_MINING_FAILED, Message: "nothing was mined"}
+	}
+	return bc.Tip(), nil
+}
+
+func rpcStartMining(bcs *BlockchainServer, params json.RawMessage) (interface{}, *rpcError) {
+	bcs.GetBlockchain().StartMining()
+	return true, nil
+}
+
+func rpcStopMining(bcs *BlockchainServer, params json.RawMessage) (interface{}, *rpcError) {
+	bcs.GetBlockchain().StopMining()
+	return false, nil
+}
+
+func rpcGetMiningStatus(bcs *BlockchainServer, params json.RawMessage) (interface{}, *rpcError) {
+	return bcs.GetBlockchain().IsMining(), nil
+}
+
+// validID reports whether id is absent or a string, number or null.
+func validID(id json.RawMessage) bool {
+	if len(id) == 0 {
+		return true
+	}
+	switch id[0] {
+	case '"', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', 'n':
+		return true
+	}
+	return false
+}
+
+// call runs one request and returns its response, or nil for a
+// notification. admin tells whether the caller gave the admin token.
+func (bcs *BlockchainServer) call(raw json.RawMessage, admin bool) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" || !validID(req.ID) {
+		return &rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: RPC_INVALID_REQUEST, Message: "invalid request"}, ID: json.RawMessage("null")}
+	}
+	method, ok := rpcMethods[req.Method]
+	var result interface{}
+	var rpcErr *rpcError
+	if !ok {
+		rpcErr = &rpcError{Code: RPC_METHOD_NOT_FOUND, Message: "method not found"}
+	} else if rpcAdminMethods[req.Method] && !admin {
+		rpcErr = &rpcError{Code: RPC_UNAUTHORIZED, Message: "admin token required"}
+	} else {
+		result, rpcErr = method(bcs, req.Params)
+	}
+	if len(req.ID) == 0 {
+		return nil
+	}
+	if rpcErr != nil {
+		return &rpcResponse{JSONRPC: "2.0", Error: rpcErr, ID: req.ID}
+	}
+	if result == nil {
+		result = json.RawMessage("null")
+	}
+	return &rpcResponse{JSONRPC: "2.0", Result: result, ID: req.ID}
+}
+
+// RPC serves JSON-RPC 2.0 requests, singly or in batches.
+func (bcs *BlockchainServer) RPC(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			utils.WriteError(w, utils.DecodeError(err))
+			return
+		}
+		body = bytes.TrimSpace(body)
+		admin := bcs.isAdmin(r)
+		w.Header().Add("Content-Type", "application/json")
+
+		var m []byte
+		if len(body) > 0 && body[0] == '[' {
+			var batch []json.RawMessage
+			if err := json.Unmarshal(body, &batch); err != nil {
+				m, _ = json.Marshal(rpcParseError(err))
+			} else if len(batch) == 0 || len(batch) > RPC_MAX_BATCH {
+				m, _ = json.Marshal(&rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: RPC_INVALID_REQUEST, Message: "invalid batch size"}, ID: json.RawMessage("null")})
+			} else {
+				responses := make([]*rpcResponse, 0, len(batch))
+				for _, raw := range batch {
+					if resp := bcs.call(raw, admin); resp != nil {
+						responses = append(responses, resp)
+					}
+				}
+				if len(responses) == 0 {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				m, _ = json.Marshal(responses)
+			}
+		} else if !json.Valid(body) {
+			m, _ = json.Marshal(rpcParseError(errors.New("invalid JSON")))
+		} else {
+			resp := bcs.call(body, admin)
+			if resp == nil {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			// A lone admin call is refused as the admin routes refuse it.
+			// A batch still answers 200, its other calls may have run.
+			if resp.Error != nil && resp.Error.Code == RPC_UNAUTHORIZED {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+			m, _ = json.Marshal(resp)
+		}
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, http.MethodPost)
+	}
+}
+
+func rpcParseError(err error) *rpcResponse {
+	return &rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: RPC_PARSE_ERROR, Message: "parse error", Data: err.Error()}, ID: json.RawMessage("null")}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/palmcivet7/go-blockchain/utils"
+)
+
+// postRPC sends body to the JSON-RPC endpoint, with token as the bearer
+// token unless it is empty.
+func postRPC(t *testing.T, url string, body string, token string) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url+utils.API_V1_PREFIX+"/rpc", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	m, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, m
+}
+
+func decodeRPC(t *testing.T, m []byte) *rpcResponse {
+	t.Helper()
+	var resp rpcResponse
+	if err := json.Unmarshal(m, &resp); err != nil {
+		t.Fatalf("%s: %v", m, err)
+	}
+	return &resp
+}
+
+func TestRPCErrors(t *testing.T) {
+	_, srv := newTestServer(t)
+	for _, c := range []struct {
+		name string
+		body string
+		code int
+	}{
+		{"parse error", `{"jsonrpc": "2.0", "method": `, RPC_PARSE_ERROR},
+		{"batch parse error", `[{"jsonrpc": "2.0"`, RPC_PARSE_ERROR},
+		{"wrong version", `{"jsonrpc": "1.0", "method": "getBlockCount", "id": 1}`, RPC_INVALID_REQUEST},
+		{"no method", `{"jsonrpc": "2.0", "id": 1}`, RPC_INVALID_REQUEST},
+		{"object id", `{"jsonrpc": "2.0", "method": "getBlockCount", "id": {}}`, RPC_INVALID_REQUEST},
+		{"empty batch", `[]`, RPC_INVALID_REQUEST},
+		{"unknown method", `{"jsonrpc": "2.0", "method": "nope", "id": 1}`, RPC_METHOD_NOT_FOUND},
+		{"bad params", `{"jsonrpc": "2.0", "method": "getBlockByHeight", "params": [-1], "id": 1}`, RPC_INVALID_PARAMS},
+		{"not found", `{"jsonrpc": "2.0", "method": "getBlockByHeight", "params": {"height": 5}, "id": 1}`, RPC_NOT_FOUND},
+	} {
+		status, m := postRPC(t, srv.URL, c.body, "")
+		resp := decodeRPC(t, m)
+		if status != http.StatusOK || resp.Error == nil || resp.Error.Code != c.code {
+			t.Errorf("%s: %d %s, want error %d", c.name, status, m, c.code)
+		}
+	}
+}
+
+func TestRPCBatch(t *testing.T) {
+	_, srv := newTestServer(t)
+	status, m := postRPC(t, srv.URL, `[
+		{"jsonrpc": "2.0", "method": "getBlockCount", "id": 1},
+		{"jsonrpc": "2.0", "method": "getBlockCount"},
+		{"jsonrpc": "2.0", "method": "nope", "id": "two"},
+		{"jsonrpc": "2.0", "method": "getBlockByHeight", "params": [0], "id": 3}
+	]`, "")
+	if status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	var responses []*rpcResponse
+	if err := json.Unmarshal(m, &responses); err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 3 {
+		t.Fatalf("%d responses, want one for each request but the notification: %s", len(responses), m)
+	}
+	if string(responses[0].ID) != "1" || responses[0].Error != nil || responses[0].Result.(float64) != 1 {
+		t.Errorf("getBlockCount: %s", m)
+	}
+	if string(responses[1].ID) != `"two"` || responses[1].Error == nil || responses[1].Error.Code != RPC_METHOD_NOT_FOUND {
+		t.Errorf("unknown method: %s", m)
+	}
+	if string(responses[2].ID) != "3" || responses[2].Error != nil {
+		t.Errorf("getBlockByHeight: %s", m)
+	}
+
+	tooMany := "[" + strings.TrimSuffix(strings.Repeat(`{"jsonrpc": "2.0", "method": "getBlockCount", "id": 1},`, RPC_MAX_BATCH+1), ",") + "]"
+	_, m = postRPC(t, srv.URL, tooMany, "")
+	if resp := decodeRPC(t, m); resp.Error == nil || resp.Error.Code != RPC_INVALID_REQUEST {
+		t.Errorf("a batch over RPC_MAX_BATCH: %s", m)
+	}
+}
+
+func TestRPCNotifications(t *testing.T) {
+	_, srv := newTestServer(t)
+	for _, body := range []string{
+		`{"jsonrpc": "2.0", "method": "getBlockCount"}`,
+		`{"jsonrpc": "2.0", "method": "nope"}`,
+		`[{"jsonrpc": "2.0", "method": "getBlockCount"}, {"jsonrpc": "2.0", "method": "getNonce", "params": ["x"]}]`,
+	} {
+		if status, m := postRPC(t, srv.URL, body, ""); status != http.StatusNoContent || len(m) != 0 {
+			t.Errorf("%s: %d %q, want an empty %d", body, status, m, http.StatusNoContent)
+		}
+	}
+}
+
+func TestRPCAdminMethods(t *testing.T) {
+	bcs, srv := newTestServer(t)
+	for method := range rpcAdminMethods {
+		if method == "mine" || method == "startMining" {
+			// Covered by the token check alone, they would start work.
+			continue
+		}
+		body := `{"jsonrpc": "2.0", "method": "` + method + `", "id": 1}`
+		for _, token := range []string{"", "wrong"} {
+			_, m := postRPC(t, srv.URL, body, token)
+			if resp := decodeRPC(t, m); resp.Error == nil || resp.Error.Code != RPC_UNAUTHORIZED {
+				t.Errorf("%s with token %q: %s", method, token, m)
+			}
+		}
+		_, m := postRPC(t, srv.URL, body, bcs.opts.AdminToken)
+		if resp := decodeRPC(t, m); resp.Error != nil {
+			t.Errorf("%s with the admin token: %s", method, m)
+		}
+	}
+
+	for _, method := range []string{"mine", "startMining"} {
+		_, m := postRPC(t, srv.URL, `{"jsonrpc": "2.0", "method": "`+method+`", "id": 1}`, "wrong")
+		if resp := decodeRPC(t, m); resp.Error == nil || resp.Error.Code != RPC_UNAUTHORIZED {
+			t.Errorf("%s with a wrong token: %s", method, m)
+		}
+	}
+}
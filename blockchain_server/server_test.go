@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/palmcivet7/go-blockchain/block"
+	"github.com/palmcivet7/go-blockchain/utils"
+	"github.com/palmcivet7/go-blockchain/wallet"
+)
+
+// newTestServer serves a devnet node that neither mines nor syncs.
+func newTestServer(t *testing.T) (*BlockchainServer, *httptest.Server) {
+	t.Helper()
+	return serveParams(t, block.DevnetParams)
+}
+
+// newFundedTestServer is newTestServer with a genesis block that gives
+// funds to a new wallet.
+func newFundedTestServer(t *testing.T, funds float64) (*BlockchainServer, *httptest.Server, *wallet.Wallet) {
+	t.Helper()
+	w := wallet.NewWallet(block.DevnetParams.AddressVersion)
+	params := *block.DevnetParams
+	params.GenesisAllocations = map[string]float64{w.BlockchainAddress(): funds}
+	bcs, srv := serveParams(t, &params)
+	return bcs, srv, w
+}
+
+func serveParams(t *testing.T, params *block.ChainParams) (*BlockchainServer, *httptest.Server) {
+	t.Helper()
+	delete(cache, "blockchain")
+	opts := &Options{
+		Params:      params,
+		Mempool:     block.NewMempool(block.MEMPOOL_MAX_COUNT, block.MEMPOOL_MAX_BYTES, block.MEMPOOL_MAX_PER_SENDER, time.Hour),
+		AdminToken:  "token",
+		Limiter:     utils.NewLimiter(0, 0, utils.MAX_BODY_BYTES),
+		PeerLimiter: utils.NewLimiter(0, 0, utils.MAX_BODY_BYTES),
+	}
+	bcs := NewBlockchainServer(0, block.NewPeerManager(block.PEER_BAN_THRESHOLD, time.Hour), opts)
+	bcs.registerMetrics()
+	srv := httptest.NewServer(bcs.NewMux())
+	t.Cleanup(srv.Close)
+	return bcs, srv
+}
+
+// addSigned puts a transaction from w to receiver in the mempool.
+func addSigned(t *testing.T, bcs *BlockchainServer, w *wallet.Wallet, receiver string, value float64, nonce uint64) {
+	t.Helper()
+	s := wallet.NewTransaction(w.PrivateKey(), w.PublicKey(), w.BlockchainAddress(), receiver, value, 0, nonce).
+		GenerateSignature(bcs.opts.Params.ChainID)
+	if err := bcs.GetBlockchain().AddTransaction(w.BlockchainAddress(), receiver, value, 0, nonce, w.PublicKey(), s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newPagedTestServer serves a chain of three blocks, each confirming a
+// transaction from w, the wallet the genesis block funds.
+func newPagedTestServer(t *testing.T) (*httptest.Server, *wallet.Wallet) {
+	t.Helper()
+	bcs, srv, w := newFundedTestServer(t, 100)
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		addSigned(t, bcs, w, "bob", 1, nonce)
+		if !bcs.GetBlockchain().Mining() {
+			t.Fatal("nothing was mined")
+		}
+	}
+	return srv, w
+}
+
+func getJSON(t *testing.T, url string, v interface{}) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestBlocksPage(t *testing.T) {
+	srv, _ := newPagedTestServer(t)
+	url := srv.URL + utils.API_V1_PREFIX + "/blocks"
+	for _, c := range []struct {
+		query   string
+		heights []int
+		next    int // 0 for none
+	}{
+		{"?limit=2", []int{0, 1}, 2},
+		{"?from=2&limit=2", []int{2, 3}, 0},
+		{"?from=1&to=2", []int{1, 2}, 0},
+		{"?from=3", []int{3}, 0},
+		{"?from=10", []int{}, 0},
+	} {
+		var page struct {
+			Blocks []struct {
+				Height int `json:"height"`
+			} `json:"blocks"`
+			Next   *int `json:"next"`
+			Height int  `json:"height"`
+		}
+		if status := getJSON(t, url+c.query, &page); status != http.StatusOK {
+			t.Errorf("%s: status %d", c.query, status)
+			continue
+		}
+		heights := make([]int, 0)
+		for _, b := range page.Blocks {
+			heights = append(heights, b.Height)
+		}
+		next := 0
+		if page.Next != nil {
+			next = *page.Next
+		}
+		if fmt.Sprint(heights) != fmt.Sprint(c.heights) || next != c.next || page.Height != 3 {
+			t.Errorf("%s: heights %v, next %v, height %d", c.query, heights, next, page.Height)
+		}
+	}
+
+	for _, query := range []string{"?from=-1", "?from=x", "?from=2&to=1", "?limit=0", "?limit=x"} {
+		var e utils.APIError
+		if status := getJSON(t, url+query, &e); status != http.StatusBadRequest || e.Code != utils.ERR_CODE_INVALID_PARAMETER {
+			t.Errorf("%s: %d %s, want %d", query, status, e.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAddressTransactionsPage(t *testing.T) {
+	srv, w := newPagedTestServer(t)
+	url := srv.URL + utils.API_V1_PREFIX + "/address/" + w.BlockchainAddress() + "/transactions"
+	// The genesis allocation and the three spends.
+	for _, c := range []struct {
+		query string
+		count int
+		total int
+		next  int // 0 for none
+	}{
+		{"?limit=3", 3, 4, 3},
+		{"?offset=3&limit=3", 1, 4, 0},
+		{"?offset=10", 0, 4, 0},
+		{"?direction=in", 1, 1, 0},
+		{"?direction=out&limit=1", 1, 3, 1},
+	} {
+		var page addressTransactionsResponse
+		if status := getJSON(t, url+c.query, &page); status != http.StatusOK {
+			t.Errorf("%s: status %d", c.query, status)
+			continue
+		}
+		next := 0
+		if page.Next != nil {
+			next = *page.Next
+		}
+		if len(page.Transactions) != c.count || page.Total != c.total || next != c.next {
+			t.Errorf("%s: %d transactions, total %d, next %d", c.query, len(page.Transactions), page.Total, next)
+		}
+	}
+
+	var page addressTransactionsResponse
+	if status := getJSON(t, srv.URL+utils.API_V1_PREFIX+"/address/nobody/transactions", &page); status != http.StatusOK || page.Total != 0 || page.Next != nil {
+		t.Errorf("unknown address: status %d, total %d", status, page.Total)
+	}
+
+	for _, query := range []string{"?offset=-1", "?offset=x", "?limit=0", "?limit=x", "?direction=sideways"} {
+		var e utils.APIError
+		if status := getJSON(t, url+query, &e); status != http.StatusBadRequest || e.Code != utils.ERR_CODE_INVALID_PARAMETER {
+			t.Errorf("%s: %d %s, want %d", query, status, e.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// Peers relay transactions with inv and getdata, not by PUT.
+func TestTransactionsRefusesPut(t *testing.T) {
+	_, srv := newTestServer(t)
+	req, err := http.NewRequest(http.MethodPut, srv.URL+utils.API_V1_PREFIX+"/transactions", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || strings.Contains(resp.Header.Get("Allow"), http.MethodPut) {
+		t.Fatalf("%s, Allow %q", resp.Status, resp.Header.Get("Allow"))
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/palmcivet7/go-blockchain/block"
+	"github.com/palmcivet7/go-blockchain/utils"
+)
+
+const (
+	WS_EVENT_BUFFER       = 256
+	WS_WRITE_TIMEOUT_SEC  = 10
+	WS_PING_INTERVAL_SEC  = 30
+	WS_MAX_DROPPED        = 1024
+	WS_MAX_ADDRESSES      = 100
+	WS_TOPIC_BLOCKS       = "blocks"
+	WS_TOPIC_TRANSACTIONS = "transactions"
+	WS_TOPIC_ADDRESS      = "address"
+	WS_ACTION_SUBSCRIBE   = "subscribe"
+	WS_ACTION_UNSUBSCRIBE = "unsubscribe"
+)
+
+// wsRequest is sent by clients, for example
+// {"action": "subscribe", "topic": "address", "address": "..."}.
+type wsRequest struct {
+	Action  string `json:"action"`
+	Topic   string `json:"topic"`
+	Address string `json:"address,omitempty"`
+}
+
+type wsMessage struct {
+	Type        string             `json:"type"`
+	Topic       string             `json:"topic,omitempty"`
+	Address     string             `json:"address,omitempty"`
+	Status      string             `json:"status,omitempty"`
+	Hash        string             `json:"hash,omitempty"`
+	Height      *int               `json:"height,omitempty"`
+	Block       *block.Block       `json:"block,omitempty"`
+	Transaction *block.Transaction `json:"transaction,omitempty"`
+	Dropped     int                `json:"dropped,omitempty"`
+	Message     string             `json:"message,omitempty"`
+}
+
+// wsClient is one WebSocket connection and what it subscribed to.
+type wsClient struct {
+	ws           *utils.WebSocket
+	blocks       bool
+	transactions bool
+	addresses    map[string]bool
+	replies      chan *wsMessage
+	mux          sync.Mutex
+}
+
+// WebSocket streams new blocks, pending transactions and the activity of
+// chosen addresses. Events are buffered per client; a client that cannot
+// keep up is told how many events it missed and is disconnected once it
+// falls too far behind.
+func (bcs *BlockchainServer) WebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := utils.UpgradeWebSocket(w, r, bcs.opts.WSOrigins)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return
+	}
+	defer ws.Close()
+
+	sub := bcs.GetBlockchain().Events().Subscribe(WS_EVENT_BUFFER,
+		block.EventNewTransaction, block.EventBlockConnected, block.EventBlockDisconnected)
+	defer sub.Unsubscribe()
+
+	c := &wsClient{ws: ws, addresses: make(map[string]bool), replies: make(chan *wsMessage, 16)}
+	done := make(chan struct{})
+	go c.readLoop(done)
+	c.writeLoop(sub, done)
+}
+
+func (c *wsClient) readLoop(done chan struct{}) {
+	defer close(done)
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.reply(&wsMessage{Type: "error", Message: "malformed request"}, done)
+			continue
+		}
+		c.reply(c.handle(&req), done)
+	}
+}
+
+func (c *wsClient) reply(m *wsMessage, done chan struct{}) {
+	select {
+	case c.replies <- m:
+	case <-done:
+	}
+}
+
+func (c *wsClient) handle(req *wsRequest) *wsMessage {
+	subscribe := req.Action == WS_ACTION_SUBSCRIBE
+	if !subscribe && req.Action != WS_ACTION_UNSUBSCRIBE {
+		return &wsMessage{Type: "error", Message: "unknown action"}
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	switch req.Topic {
+	case WS_TOPIC_BLOCKS:
+		c.blocks = subscribe
+	case WS_TOPIC_TRANSACTIONS:
+		c.transactions = subscribe
+	case WS_TOPIC_ADDRESS:
+		if req.Address == "" {
+			return &wsMessage{Type: "error", Message: "missing address"}
+		}
+		if subscribe && !c.addresses[req.Address] && len(c.addresses) >= WS_MAX_ADDRESSES {
+			return &wsMessage{Type: "error", Message: "too many addresses"}
+		}
+		if subscribe {
+			c.addresses[req.Address] = true
+		} else {
+			delete(c.addresses, req.Address)
+		}
+	default:
+		return &wsMessage{Type: "error", Message: "unknown topic"}
+	}
+	return &wsMessage{Type: req.Action + "d", Topic: req.Topic, Address: req.Address}
+}
+
+// messages turns an event into what this client subscribed to see.
+func (c *wsClient) messages(e *block.Event) []*wsMessage {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	messages := make([]*wsMessage, 0)
+	switch e.Type {
+	case block.EventNewTransaction:
+		t := e.Transaction
+		hash := t.Hash()
+		if c.transactions {
+			messages = append(messages, &wsMessage{Type: "transaction", Hash: hex.EncodeToString(hash[:]), Transaction: t})
+		}
+		for _, a := range []string{t.SenderAddress(), t.ReceiverAddress()} {
+			if c.addresses[a] {
+				messages = append(messages, &wsMessage{Type: "address", Address: a, Status: "pending", Hash: hex.EncodeToString(hash[:]), Transaction: t})
+			}
+		}
+	case block.EventBlockConnected, block.EventBlockDisconnected:
+		b := e.Block
+		height := b.Height()
+		status := "confirmed"
+		if e.Type == block.EventBlockDisconnected {
+			status = "unconfirmed"
+			if c.blocks {
+				hash := b.Hash()
+				messages = append(messages, &wsMessage{Type: "block_disconnected", Hash: hex.EncodeToString(hash[:]), Height: &height})
+			}
+		} else if c.blocks {
+			messages = append(messages, &wsMessage{Type: "block", Block: b})
+		}
+		if len(c.addresses) == 0 {
+			break
+		}
+		for _, t := range b.Transactions() {
+			hash := t.Hash()
+			for _, a := range []string{t.SenderAddress(), t.ReceiverAddress()} {
+				if c.addresses[a] {
+					messages = append(messages, &wsMessage{Type: "address", Address: a, Status: status, Hash: hex.EncodeToString(hash[:]), Height: &height, Transaction: t})
+				}
+			}
+		}
+	}
+	return messages
+}
+
+func (c *wsClient) write(m *wsMessage) error {
+	data, _ := json.Marshal(m)
+	return c.ws.WriteText(data, time.Second*WS_WRITE_TIMEOUT_SEC)
+}
+
+func (c *wsClient) writeLoop(sub *block.Subscription, done chan struct{}) {
+	ping := time.NewTicker(time.Second * WS_PING_INTERVAL_SEC)
+	defer ping.Stop()
+
+	reported := 0
+	for {
+		select {
+		case <-done:
+			return
+		case m := <-c.replies:
+			if err := c.write(m); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := c.ws.WritePing(time.Second * WS_WRITE_TIMEOUT_SEC); err != nil {
+				return
+			}
+		case e, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if dropped := sub.Dropped(); dropped > reported {
+				if dropped > WS_MAX_DROPPED {
+					log.Printf("action=websocket_disconnect, client=%s, dropped=%d", c.ws.RemoteAddr(), dropped)
+					c.ws.WriteClose(utils.WsClosePolicy, "client too slow")
+					return
+				}
+				reported = dropped
+				if err := c.write(&wsMessage{Type: "lagged", Dropped: dropped}); err != nil {
+					return
+				}
+			}
+			for _, m := range c.messages(e) {
+				if err := c.write(m); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -2,7 +2,6 @@ module github.com/palmcivet7/go-blockchain
 
 go 1.21.0
 
-require (
-	github.com/btcsuite/btcutil v1.0.2 // indirect
-	golang.org/x/crypto v0.14.0 // indirect
-)
+require github.com/btcsuite/btcutil v1.0.2
+
+require golang.org/x/crypto v0.14.0 // indirect
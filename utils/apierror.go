@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// API_V1_PREFIX is where the blockchain server serves version 1 of its REST
+// API.
+const API_V1_PREFIX = "/api/v1"
+
+// Machine readable error codes returned by the blockchain and wallet
+// servers alongside a human readable message.
+const (
+	ERR_CODE_BAD_REQUEST             = "BAD_REQUEST"
+	ERR_CODE_MALFORMED_JSON          = "MALFORMED_JSON"
+	ERR_CODE_MISSING_FIELD           = "MISSING_FIELD"
+	ERR_CODE_INVALID_PARAMETER       = "INVALID_PARAMETER"
+	ERR_CODE_BAD_PUBLIC_KEY          = "BAD_PUBLIC_KEY"
+	ERR_CODE_BAD_PRIVATE_KEY         = "BAD_PRIVATE_KEY"
+	ERR_CODE_BAD_SIGNATURE           = "BAD_SIGNATURE"
+	ERR_CODE_BAD_RAW_TRANSACTION     = "BAD_RAW_TRANSACTION"
+	ERR_CODE_INVALID_SENDER          = "INVALID_SENDER"
+	ERR_CODE_INVALID_VALUE           = "INVALID_VALUE"
+	ERR_CODE_INVALID_FEE             = "INVALID_FEE"
+	ERR_CODE_INSUFFICIENT_FUNDS      = "INSUFFICIENT_FUNDS"
+	ERR_CODE_NONCE_TOO_LOW           = "NONCE_TOO_LOW"
+	ERR_CODE_DUPLICATE_TRANSACTION   = "DUPLICATE_TRANSACTION"
+	ERR_CODE_REPLACEMENT_UNDERPRICED = "REPLACEMENT_UNDERPRICED"
+	ERR_CODE_SENDER_LIMIT            = "SENDER_LIMIT"
+	ERR_CODE_MEMPOOL_FULL            = "MEMPOOL_FULL"
+	ERR_CODE_TRANSACTION_TOO_LARGE   = "TRANSACTION_TOO_LARGE"
+	ERR_CODE_NOTHING_TO_MINE         = "NOTHING_TO_MINE"
+	ERR_CODE_NOT_FOUND               = "NOT_FOUND"
+	ERR_CODE_METHOD_NOT_ALLOWED      = "METHOD_NOT_ALLOWED"
+	ERR_CODE_UNAUTHORIZED            = "UNAUTHORIZED"
+	ERR_CODE_RATE_LIMITED            = "RATE_LIMITED"
+	ERR_CODE_CONCURRENCY_LIMITED     = "CONCURRENCY_LIMITED"
+	ERR_CODE_BODY_TOO_LARGE          = "BODY_TOO_LARGE"
+	ERR_CODE_BANNED                  = "BANNED"
+	ERR_CODE_WRONG_NETWORK           = "WRONG_NETWORK"
+	ERR_CODE_GATEWAY_ERROR           = "GATEWAY_ERROR"
+	ERR_CODE_INTERNAL_ERROR          = "INTERNAL_ERROR"
+)
+
+// APIError is the body of every error response, for example
+// {"code": "NONCE_TOO_LOW", "message": "transaction nonce is already
+// confirmed"}, sent with Status.
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func NewAPIError(status int, code string, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func BadRequest(code string, message string) *APIError {
+	return NewAPIError(http.StatusBadRequest, code, message)
+}
+
+func NotFound(message string) *APIError {
+	return NewAPIError(http.StatusNotFound, ERR_CODE_NOT_FOUND, message)
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// WriteError answers with err, which is sent as an internal error unless it
+// is an *APIError.
+func WriteError(w http.ResponseWriter, err error) {
+	e, ok := err.(*APIError)
+	if !ok {
+		e = NewAPIError(http.StatusInternalServerError, ERR_CODE_INTERNAL_ERROR, err.Error())
+	}
+	m, _ := json.Marshal(e)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	io.WriteString(w, string(m))
+}
+
+// DecodeError is the error for a request body that could not be read or
+// decoded.
+func DecodeError(err error) *APIError {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return NewAPIError(http.StatusRequestEntityTooLarge, ERR_CODE_BODY_TOO_LARGE, "request body too large")
+	}
+	return BadRequest(ERR_CODE_MALFORMED_JSON, err.Error())
+}
+
+// MethodNotAllowed answers 405 listing the allowed methods in the Allow
+// header.
+func MethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	WriteError(w, NewAPIError(http.StatusMethodNotAllowed, ERR_CODE_METHOD_NOT_ALLOWED, "method not allowed"))
+}
+
+// ReadAPIError decodes an error response from another server, falling back
+// to a gateway error if the body is not one.
+func ReadAPIError(resp *http.Response) *APIError {
+	var e APIError
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Code == "" {
+		return NewAPIError(http.StatusBadGateway, ERR_CODE_GATEWAY_ERROR, "gateway answered "+resp.Status)
+	}
+	e.Status = resp.StatusCode
+	return &e
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const ADMIN_TOKEN_BYTES = 32
+
+// LoadOrCreateAdminToken reads the token that authorizes admin requests,
+// creating a random one on first run.
+func LoadOrCreateAdminToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		b := make([]byte, ADMIN_TOKEN_BYTES)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		token := hex.EncodeToString(b)
+		if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+			return "", err
+		}
+		return token, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("%s: empty admin token", path)
+	}
+	return token, nil
+}
+
+// BearerToken returns the token of an "Authorization: Bearer" header.
+func BearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// Authorized reports whether r carries token. An empty token authorizes
+// nothing.
+func Authorized(r *http.Request, token string) bool {
+	given := BearerToken(r)
+	return token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// Unauthorized answers 401, asking for the admin bearer token.
+func Unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+	WriteError(w, NewAPIError(http.StatusUnauthorized, ERR_CODE_UNAUTHORIZED, "admin token required"))
+}
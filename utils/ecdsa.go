@@ -1,49 +1,125 @@
 package utils
 
 import (
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/big"
 )
 
+var (
+	ErrPublicKeyLength  = errors.New("public key must be 128 hex characters")
+	ErrPublicKeyHex     = errors.New("public key is not hex")
+	ErrPublicKeyInvalid = errors.New("public key is not a point on the P-256 curve")
+	ErrSignatureLength  = errors.New("signature must be 128 hex characters")
+	ErrSignatureHex     = errors.New("signature is not hex")
+	ErrSignatureRange   = errors.New("signature R and S must be between 1 and the curve order")
+	ErrSignatureHighS   = errors.New("signature S must be in the lower half of the curve order")
+	ErrPrivateKeyLength = errors.New("private key must be at most 64 hex characters")
+	ErrPrivateKeyHex    = errors.New("private key is not hex")
+	ErrPrivateKeyRange  = errors.New("private key must be between 1 and the curve order")
+	ErrPrivateKeyPublic = errors.New("private key does not match the public key")
+)
 
 type Signature struct {
 	R *big.Int
 	S *big.Int
 }
 
+// NewSignature returns the signature with S in the lower half of the curve
+// order, the only form nodes accept. (R, S) and (R, N-S) are both valid,
+// so without this anyone could change a transaction's signature.
+func NewSignature(r *big.Int, s *big.Int) *Signature {
+	n := elliptic.P256().Params().N
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s = new(big.Int).Sub(n, s)
+	}
+	return &Signature{R: r, S: s}
+}
+
 func (s *Signature) String() string {
 	return fmt.Sprintf("%064x%064x", s.R, s.S)
-}  
-
-func String2BitIntTuple(s string) (big.Int, big.Int) {
-	bx, _ := hex.DecodeString(s[:64])
-	by, _ := hex.DecodeString(s[64:])
-
-	var bix big.Int
-	var biy big.Int
-
-	_ = bix.SetBytes(bx)
-	_ = biy.SetBytes(by)
+}
 
-	return bix, biy
+// String2BitIntTuple splits 128 hex characters into two 32 byte integers.
+func String2BitIntTuple(s string) (*big.Int, *big.Int, error) {
+	if len(s) != 128 {
+		return nil, nil, fmt.Errorf("expected 128 hex characters, got %d", len(s))
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return new(big.Int).SetBytes(b[:32]), new(big.Int).SetBytes(b[32:]), nil
 }
 
-func SignatureFromString(s string) *Signature {
-	x, y := String2BitIntTuple(s)
-	return &Signature{&x, &y}
+// SignatureFromString parses a signature and checks it is in range and in
+// low S form.
+func SignatureFromString(s string) (*Signature, error) {
+	if len(s) != 128 {
+		return nil, ErrSignatureLength
+	}
+	r, sv, err := String2BitIntTuple(s)
+	if err != nil {
+		return nil, ErrSignatureHex
+	}
+	n := elliptic.P256().Params().N
+	if r.Sign() == 0 || sv.Sign() == 0 || r.Cmp(n) >= 0 || sv.Cmp(n) >= 0 {
+		return nil, ErrSignatureRange
+	}
+	if sv.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		return nil, ErrSignatureHighS
+	}
+	return &Signature{r, sv}, nil
 }
 
-func PublicKeyFromString(s string) *ecdsa.PublicKey {
-	x, y := String2BitIntTuple(s)
-	return &ecdsa.PublicKey{elliptic.P256(), &x, &y}
+// PublicKeyFromString parses the X and Y coordinates of a public key and
+// checks they are a point on P-256.
+func PublicKeyFromString(s string) (*ecdsa.PublicKey, error) {
+	if len(s) != 128 {
+		return nil, ErrPublicKeyLength
+	}
+	x, y, err := String2BitIntTuple(s)
+	if err != nil {
+		return nil, ErrPublicKeyHex
+	}
+	point := make([]byte, 65)
+	point[0] = 4
+	x.FillBytes(point[1:33])
+	y.FillBytes(point[33:])
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, ErrPublicKeyInvalid
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
 }
 
-func PrivateKeyFromString(s string, publicKey *ecdsa.PublicKey) *ecdsa.PrivateKey {
-	b, _ := hex.DecodeString(s[:])
-	var bi big.Int
-	_ = bi.SetBytes(b)
-	return &ecdsa.PrivateKey{*publicKey, &bi }
-} 
\ No newline at end of file
+// PrivateKeyFromString parses a private key and checks it belongs to
+// publicKey.
+func PrivateKeyFromString(s string, publicKey *ecdsa.PublicKey) (*ecdsa.PrivateKey, error) {
+	if len(s) == 0 || len(s) > 64 {
+		return nil, ErrPrivateKeyLength
+	}
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, ErrPrivateKeyHex
+	}
+	d := new(big.Int).SetBytes(b)
+	if d.Sign() == 0 || d.Cmp(elliptic.P256().Params().N) >= 0 {
+		return nil, ErrPrivateKeyRange
+	}
+	key, err := ecdh.P256().NewPrivateKey(d.FillBytes(make([]byte, 32)))
+	if err != nil {
+		return nil, ErrPrivateKeyRange
+	}
+	point := key.PublicKey().Bytes()
+	if new(big.Int).SetBytes(point[1:33]).Cmp(publicKey.X) != 0 || new(big.Int).SetBytes(point[33:]).Cmp(publicKey.Y) != 0 {
+		return nil, ErrPrivateKeyPublic
+	}
+	return &ecdsa.PrivateKey{PublicKey: *publicKey, D: d}, nil
+}
@@ -0,0 +1,312 @@
+package utils
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// HTTP_DURATION_BUCKETS are the upper bounds, in seconds, of the request
+// latency histogram.
+var HTTP_DURATION_BUCKETS = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Sample is one value of a metric, with the values of its labels in the
+// order the labels were declared.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+type metric struct {
+	name      string
+	help      string
+	kind      string
+	labels    []string
+	collect   func() []Sample
+	histogram *Histogram
+}
+
+// Registry holds metrics and writes them in the Prometheus text exposition
+// format.
+type Registry struct {
+	metrics      []*metric
+	httpDuration *Histogram
+	httpOnce     sync.Once
+	mux          sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewFunc registers a metric whose samples are read from collect at every
+// scrape. kind is "counter" or "gauge".
+func (r *Registry) NewFunc(name string, help string, kind string, labels []string, collect func() []Sample) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.metrics = append(r.metrics, &metric{name: name, help: help, kind: kind, labels: labels, collect: collect})
+}
+
+func (r *Registry) NewGaugeFunc(name string, help string, f func() float64) {
+	r.NewFunc(name, help, "gauge", nil, func() []Sample {
+		return []Sample{{Value: f()}}
+	})
+}
+
+func (r *Registry) NewCounterFunc(name string, help string, f func() float64) {
+	r.NewFunc(name, help, "counter", nil, func() []Sample {
+		return []Sample{{Value: f()}}
+	})
+}
+
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// Counter is a value that only goes up, one per combination of label
+// values.
+type Counter struct {
+	values map[string]*Sample
+	mux    sync.Mutex
+}
+
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{values: make(map[string]*Sample)}
+	r.NewFunc(name, help, "counter", labels, c.samples)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	key := labelKey(labelValues)
+	s, ok := c.values[key]
+	if !ok {
+		s = &Sample{Labels: append([]string(nil), labelValues...)}
+		c.values[key] = s
+	}
+	s.Value += v
+}
+
+func (c *Counter) samples() []Sample {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	samples := make([]Sample, 0, len(c.values))
+	for _, s := range c.values {
+		samples = append(samples, *s)
+	}
+	return samples
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Histogram counts observations into buckets, one set per combination of
+// label values.
+type Histogram struct {
+	buckets []float64
+	series  map[string]*histogramSeries
+	mux     sync.Mutex
+}
+
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{buckets: buckets, series: make(map[string]*histogramSeries)}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.metrics = append(r.metrics, &metric{name: name, help: help, kind: "histogram", labels: labels, histogram: h})
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	key := labelKey(labelValues)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labels: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeSample writes name{labels} value. extra is an additional label
+// name and value, such as a histogram's le.
+func writeSample(w *bufio.Writer, name string, labels []string, values []string, extra []string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || len(extra) > 0 {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			value := ""
+			if i < len(values) {
+				value = values[i]
+			}
+			w.WriteString(l + `="` + labelEscaper.Replace(value) + `"`)
+		}
+		if len(extra) > 0 {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extra[0] + `="` + extra[1] + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteString(" " + formatValue(v) + "\n")
+}
+
+func sortSamples(samples []Sample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return labelKey(samples[i].Labels) < labelKey(samples[j].Labels)
+	})
+}
+
+func (h *Histogram) write(w *bufio.Writer, m *metric) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		for i, bound := range h.buckets {
+			writeSample(w, m.name+"_bucket", m.labels, s.labels, []string{"le", formatValue(bound)}, float64(s.counts[i]))
+		}
+		writeSample(w, m.name+"_bucket", m.labels, s.labels, []string{"le", "+Inf"}, float64(s.count))
+		writeSample(w, m.name+"_sum", m.labels, s.labels, nil, s.sum)
+		writeSample(w, m.name+"_count", m.labels, s.labels, nil, float64(s.count))
+	}
+}
+
+// Write writes every metric in the order they were registered.
+func (r *Registry) Write(out io.Writer) error {
+	r.mux.Lock()
+	metrics := append([]*metric(nil), r.metrics...)
+	r.mux.Unlock()
+
+	w := bufio.NewWriter(out)
+	for _, m := range metrics {
+		w.WriteString("# HELP " + m.name + " " + strings.ReplaceAll(m.help, "\n", " ") + "\n")
+		w.WriteString("# TYPE " + m.name + " " + m.kind + "\n")
+		if m.histogram != nil {
+			m.histogram.write(w, m)
+			continue
+		}
+		samples := m.collect()
+		sortSamples(samples)
+		for _, s := range samples {
+			writeSample(w, m.name, m.labels, s.Labels, nil, s.Value)
+		}
+	}
+	return w.Flush()
+}
+
+// Metrics serves the registry to Prometheus.
+func (r *Registry) Metrics(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", METRICS_CONTENT_TYPE)
+		r.Write(w)
+	default:
+		MethodNotAllowed(w, http.MethodGet)
+	}
+}
+
+// statusWriter remembers the status of a response. It passes flushing and
+// hijacking through so streamed and WebSocket responses still work.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	sw.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// Instrument records how long h takes to answer, by endpoint, method and
+// status code.
+func (r *Registry) Instrument(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	r.httpOnce.Do(func() {
+		r.httpDuration = r.NewHistogram("http_request_duration_seconds", "Time taken to answer HTTP requests.",
+			HTTP_DURATION_BUCKETS, "endpoint", "method", "code")
+	})
+	return func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		h(sw, req)
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		r.httpDuration.Observe(time.Since(start).Seconds(), endpoint, req.Method, strconv.Itoa(status))
+	}
+}
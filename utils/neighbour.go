@@ -11,13 +11,14 @@ import (
 )
 
 func IsFoundHost(host string, port uint16) bool {
-    target := fmt.Sprintf("%s:%d", host, port)
+    target := net.JoinHostPort(host, strconv.Itoa(int(port)))
 
-    _, err := net.DialTimeout("tcp", target, 1*time.Second)
+    conn, err := net.DialTimeout("tcp", target, 1*time.Second)
     if err != nil {
         fmt.Printf("%s %v\n", target, err)
         return false
     }
+    conn.Close()
     return true
 }
 
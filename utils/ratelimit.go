@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	RATE_LIMIT_PER_SEC      = 20
+	RATE_LIMIT_BURST        = 40
+	MAX_BODY_BYTES          = 1 << 20
+	MAX_CONCURRENT_REQUESTS = 64
+
+	// Neighbours announce every transaction and block, and sync in
+	// batches, so they are allowed more than API clients.
+	PEER_RATE_LIMIT_PER_SEC = 100
+	PEER_RATE_LIMIT_BURST   = 200
+
+	// Buckets of clients that have been quiet long enough to refill are
+	// forgotten this often.
+	RATE_LIMIT_SWEEP_INTERVAL = time.Minute
+)
+
+// LimitStats counts what a Limiter did with the requests to one endpoint.
+type LimitStats struct {
+	Allowed            uint64 `json:"allowed"`
+	RateLimited        uint64 `json:"rate_limited"`
+	ConcurrencyLimited uint64 `json:"concurrency_limited"`
+	BodyTooLarge       uint64 `json:"body_too_large"`
+	InFlight           int    `json:"in_flight"`
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type endpointLimit struct {
+	slots chan struct{}
+	stats LimitStats
+}
+
+// Limiter throttles each client IP with a token bucket, caps request
+// bodies and bounds how many requests each endpoint serves at once. A nil
+// Limiter limits nothing.
+type Limiter struct {
+	rate      float64
+	burst     float64
+	maxBody   int64
+	buckets   map[string]*tokenBucket
+	endpoints map[string]*endpointLimit
+	lastSweep time.Time
+	mux       sync.Mutex
+}
+
+// NewLimiter allows each IP rate requests a second with bursts of burst,
+// and bodies of at most maxBody bytes. Zero turns a limit off.
+func NewLimiter(rate float64, burst int, maxBody int64) *Limiter {
+	return &Limiter{
+		rate:      rate,
+		burst:     float64(burst),
+		maxBody:   maxBody,
+		buckets:   make(map[string]*tokenBucket),
+		endpoints: make(map[string]*endpointLimit),
+		lastSweep: time.Now(),
+	}
+}
+
+// ClientIP is the IP a request came from. Unlike headers, the client cannot
+// choose it.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// take removes a token from ip's bucket, or returns how long until one is
+// available.
+func (l *Limiter) take(ip string, now time.Time) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+	if now.Sub(l.lastSweep) > RATE_LIMIT_SWEEP_INTERVAL {
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// limitedBody counts the requests whose body turns out to be too large
+// while it is read.
+type limitedBody struct {
+	io.ReadCloser
+	tooLarge func()
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) && b.tooLarge != nil {
+		b.tooLarge()
+		b.tooLarge = nil
+	}
+	return n, err
+}
+
+func (l *Limiter) endpoint(name string, concurrency int) *endpointLimit {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	e, ok := l.endpoints[name]
+	if !ok {
+		e = &endpointLimit{}
+		if concurrency > 0 {
+			e.slots = make(chan struct{}, concurrency)
+		}
+		l.endpoints[name] = e
+	}
+	return e
+}
+
+func (l *Limiter) count(counter *uint64) {
+	l.mux.Lock()
+	*counter++
+	l.mux.Unlock()
+}
+
+// Limit applies the limits to h, counting its requests under endpoint. At
+// most concurrency requests to the endpoint are served at once, any number
+// if it is zero. Handlers registered under the same endpoint share its
+// slots.
+func (l *Limiter) Limit(endpoint string, concurrency int, h http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return h
+	}
+	e := l.endpoint(endpoint, concurrency)
+	return func(w http.ResponseWriter, r *http.Request) {
+		l.mux.Lock()
+		ok, wait := l.take(ClientIP(r), time.Now())
+		if !ok {
+			e.stats.RateLimited++
+		}
+		l.mux.Unlock()
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			WriteError(w, NewAPIError(http.StatusTooManyRequests, ERR_CODE_RATE_LIMITED, "rate limit exceeded"))
+			return
+		}
+		if e.slots != nil {
+			select {
+			case e.slots <- struct{}{}:
+				defer func() { <-e.slots }()
+			default:
+				l.count(&e.stats.ConcurrencyLimited)
+				w.Header().Set("Retry-After", "1")
+				WriteError(w, NewAPIError(http.StatusTooManyRequests, ERR_CODE_CONCURRENCY_LIMITED, "too many requests in progress"))
+				return
+			}
+		}
+		if l.maxBody > 0 {
+			if r.ContentLength > l.maxBody {
+				l.count(&e.stats.BodyTooLarge)
+				WriteError(w, NewAPIError(http.StatusRequestEntityTooLarge, ERR_CODE_BODY_TOO_LARGE, "request body too large"))
+				return
+			}
+			r.Body = &limitedBody{http.MaxBytesReader(w, r.Body, l.maxBody), func() {
+				l.count(&e.stats.BodyTooLarge)
+			}}
+		}
+		l.count(&e.stats.Allowed)
+		h(w, r)
+	}
+}
+
+// Stats returns the counts of each endpoint.
+func (l *Limiter) Stats() map[string]LimitStats {
+	stats := make(map[string]LimitStats)
+	if l == nil {
+		return stats
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for name, e := range l.endpoints {
+		s := e.stats
+		s.InFlight = len(e.slots)
+		stats[name] = s
+	}
+	return stats
+}
+
+// RegisterMetrics exposes the counts of every endpoint on r, as metrics
+// whose names start with prefix.
+func (l *Limiter) RegisterMetrics(r *Registry, prefix string) {
+	stat := func(value func(s LimitStats) float64) func() []Sample {
+		return func() []Sample {
+			samples := make([]Sample, 0)
+			for endpoint, s := range l.Stats() {
+				samples = append(samples, Sample{Labels: []string{endpoint}, Value: value(s)})
+			}
+			return samples
+		}
+	}
+	endpoint := []string{"endpoint"}
+	r.NewFunc(prefix+"_allowed_total", "Requests the limits let through.", "counter", endpoint,
+		stat(func(s LimitStats) float64 { return float64(s.Allowed) }))
+	r.NewFunc(prefix+"_rate_limited_total", "Requests refused because their IP exceeded its rate.", "counter", endpoint,
+		stat(func(s LimitStats) float64 { return float64(s.RateLimited) }))
+	r.NewFunc(prefix+"_concurrency_limited_total", "Requests refused because the endpoint was busy.", "counter", endpoint,
+		stat(func(s LimitStats) float64 { return float64(s.ConcurrencyLimited) }))
+	r.NewFunc(prefix+"_body_too_large_total", "Requests refused because their body was too large.", "counter", endpoint,
+		stat(func(s LimitStats) float64 { return float64(s.BodyTooLarge) }))
+	r.NewFunc(prefix+"_in_flight", "Requests being served.", "gauge", endpoint,
+		stat(func(s LimitStats) float64 { return float64(s.InFlight) }))
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readBody(w http.ResponseWriter, r *http.Request) {
+	if _, err := io.ReadAll(r.Body); err != nil {
+		WriteError(w, DecodeError(err))
+	}
+}
+
+// serve sends h a request from ip and returns the response.
+func serve(h http.HandlerFunc, ip string, body io.Reader) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", body)
+	r.RemoteAddr = ip + ":1234"
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var e APIError
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatalf("%s: %v", w.Body, err)
+	}
+	return e.Code
+}
+
+func TestLimitRate(t *testing.T) {
+	l := NewLimiter(1, 2, 0)
+	h := l.Limit("/x", 0, readBody)
+	for i := 0; i < 2; i++ {
+		if w := serve(h, "192.0.2.1", nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst: %d", i, w.Code)
+		}
+	}
+	w := serve(h, "192.0.2.1", nil)
+	if w.Code != http.StatusTooManyRequests || errorCode(t, w) != ERR_CODE_RATE_LIMITED {
+		t.Fatalf("request over the burst: %d %s", w.Code, w.Body)
+	}
+	if retry := w.Header().Get("Retry-After"); retry != "1" {
+		t.Fatalf("Retry-After is %q, want 1", retry)
+	}
+	if w := serve(h, "192.0.2.2", nil); w.Code != http.StatusOK {
+		t.Fatalf("another IP was limited: %d", w.Code)
+	}
+	if stats := l.Stats()["/x"]; stats.Allowed != 3 || stats.RateLimited != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+func TestLimitRefill(t *testing.T) {
+	l := NewLimiter(2, 1, 0)
+	now := time.Now()
+	if ok, _ := l.take("192.0.2.1", now); !ok {
+		t.Fatal("the first request was refused")
+	}
+	if ok, wait := l.take("192.0.2.1", now); ok || wait != 500*time.Millisecond {
+		t.Fatalf("got %v after %v, want a wait of 500ms", ok, wait)
+	}
+	if ok, _ := l.take("192.0.2.1", now.Add(400*time.Millisecond)); ok {
+		t.Fatal("the bucket refilled early")
+	}
+	// The refused request above took nothing.
+	if ok, _ := l.take("192.0.2.1", now.Add(500*time.Millisecond)); !ok {
+		t.Fatal("the bucket did not refill")
+	}
+}
+
+func TestLimitConcurrency(t *testing.T) {
+	l := NewLimiter(0, 0, 0)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	h := l.Limit("/x", 1, func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	})
+	done := make(chan struct{})
+	go func() {
+		serve(h, "192.0.2.1", nil)
+		close(done)
+	}()
+	<-entered
+
+	w := serve(h, "192.0.2.2", nil)
+	if w.Code != http.StatusTooManyRequests || errorCode(t, w) != ERR_CODE_CONCURRENCY_LIMITED {
+		t.Fatalf("request over the limit: %d %s", w.Code, w.Body)
+	}
+	if retry := w.Header().Get("Retry-After"); retry != "1" {
+		t.Fatalf("Retry-After is %q, want 1", retry)
+	}
+	if stats := l.Stats()["/x"]; stats.InFlight != 1 || stats.ConcurrencyLimited != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+	close(release)
+	<-done
+	if stats := l.Stats()["/x"]; stats.InFlight != 0 {
+		t.Fatalf("%d requests in flight after they finished", stats.InFlight)
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	l := NewLimiter(0, 0, 4)
+	h := l.Limit("/x", 0, readBody)
+	if w := serve(h, "192.0.2.1", strings.NewReader("four")); w.Code != http.StatusOK {
+		t.Fatalf("a body at the limit: %d %s", w.Code, w.Body)
+	}
+	w := serve(h, "192.0.2.1", strings.NewReader("too long"))
+	if w.Code != http.StatusRequestEntityTooLarge || errorCode(t, w) != ERR_CODE_BODY_TOO_LARGE {
+		t.Fatalf("a declared length over the limit: %d %s", w.Code, w.Body)
+	}
+	// Without a Content-Length the body is cut off while it is read.
+	w = serve(h, "192.0.2.1", io.MultiReader(strings.NewReader("too long")))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("an undeclared length over the limit: %d %s", w.Code, w.Body)
+	}
+	if stats := l.Stats()["/x"]; stats.BodyTooLarge != 2 {
+		t.Fatalf("counted %d bodies too large, want 2", stats.BodyTooLarge)
+	}
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+)
+
+// Transactions are signed over a fixed binary encoding rather than JSON so
+// that the wallet and the node agree byte for byte whatever their structs
+// look like:
+//
+//	domain | chain id | sender | receiver | value | fee | nonce
+//
+// Strings are prefixed with their length as a uint32, the chain id is a
+// uint32, value and fee are IEEE 754 doubles and the nonce a uint64, all
+// big-endian. The domain keeps a transaction signature from being valid
+// for anything else and the chain id from being replayed on another
+// network.
+const TRANSACTION_SIGNING_DOMAIN = "go-blockchain transaction v1"
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// canonicalFloat maps -0 to 0 so both encode the same.
+func canonicalFloat(f float64) uint64 {
+	if f == 0 {
+		return 0
+	}
+	return math.Float64bits(f)
+}
+
+// TransactionSigningBytes returns the canonical encoding of a transaction
+// for signing.
+func TransactionSigningBytes(chainID uint32, sender string, receiver string, value float64, fee float64, nonce uint64) []byte {
+	b := appendString(nil, TRANSACTION_SIGNING_DOMAIN)
+	b = binary.BigEndian.AppendUint32(b, chainID)
+	b = appendString(b, sender)
+	b = appendString(b, receiver)
+	b = binary.BigEndian.AppendUint64(b, canonicalFloat(value))
+	b = binary.BigEndian.AppendUint64(b, canonicalFloat(fee))
+	return binary.BigEndian.AppendUint64(b, nonce)
+}
+
+// TransactionSigningHash is the digest a transaction signature signs.
+func TransactionSigningHash(chainID uint32, sender string, receiver string, value float64, fee float64, nonce uint64) [32]byte {
+	return sha256.Sum256(TransactionSigningBytes(chainID, sender, receiver, value, fee, nonce))
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"crypto/elliptic"
+	"encoding/hex"
+	"math"
+	"math/big"
+	"testing"
+)
+
+// Golden vectors for the canonical transaction encoding. Wallets in other
+// languages must produce the same bytes, so these must never change.
+const (
+	goldenSigningBytes = "0000001c676f2d626c6f636b636861696e207472616e73616374696f6e207631" +
+		"00000539" + // chain id 1337
+		"00000005616c696365" + // "alice"
+		"00000003626f62" + // "bob"
+		"3ff8000000000000" + // value 1.5
+		"3fd0000000000000" + // fee 0.25
+		"0000000000000007" // nonce 7
+	goldenSigningHash        = "6276e7222b679c223040a518bdbbeb7b48c99006cdfa4ff57280a3cd95d915f3"
+	goldenSigningHashChainID = "e2c8c4d2ed63c617c4909f59600ad6bedf79498945294e3574ff021e006a202c"
+)
+
+func TestTransactionSigningBytes(t *testing.T) {
+	b := TransactionSigningBytes(1337, "alice", "bob", 1.5, 0.25, 7)
+	if got := hex.EncodeToString(b); got != goldenSigningBytes {
+		t.Fatalf("signing bytes\n got %s\nwant %s", got, goldenSigningBytes)
+	}
+}
+
+func TestTransactionSigningHash(t *testing.T) {
+	h := TransactionSigningHash(1337, "alice", "bob", 1.5, 0.25, 7)
+	if got := hex.EncodeToString(h[:]); got != goldenSigningHash {
+		t.Fatalf("signing hash\n got %s\nwant %s", got, goldenSigningHash)
+	}
+	h = TransactionSigningHash(1, "alice", "bob", 1.5, 0.25, 7)
+	if got := hex.EncodeToString(h[:]); got != goldenSigningHashChainID {
+		t.Fatalf("signing hash on chain 1\n got %s\nwant %s", got, goldenSigningHashChainID)
+	}
+}
+
+func TestTransactionSigningBytesNegativeZero(t *testing.T) {
+	zero := TransactionSigningBytes(1, "alice", "bob", 0, 0, 0)
+	negative := TransactionSigningBytes(1, "alice", "bob", math.Copysign(0, -1), math.Copysign(0, -1), 0)
+	if hex.EncodeToString(zero) != hex.EncodeToString(negative) {
+		t.Fatal("-0 and 0 encode differently")
+	}
+}
+
+func TestNewSignatureLowS(t *testing.T) {
+	n := elliptic.P256().Params().N
+	r := big.NewInt(12345)
+
+	high := new(big.Int).Sub(n, big.NewInt(1))
+	s := NewSignature(r, high)
+	if s.S.Cmp(big.NewInt(1)) != 0 {
+		t.Fatalf("S = %s, want N-S = 1", s.S)
+	}
+	if _, err := SignatureFromString(s.String()); err != nil {
+		t.Fatalf("normalized signature rejected: %v", err)
+	}
+	if _, err := SignatureFromString((&Signature{r, high}).String()); err != ErrSignatureHighS {
+		t.Fatalf("high S signature: got %v, want %v", err, ErrSignatureHighS)
+	}
+
+	low := big.NewInt(42)
+	if s := NewSignature(r, low); s.S.Cmp(low) != 0 {
+		t.Fatalf("low S changed to %s", s.S)
+	}
+}
@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	NODE_CERT_VALIDITY_YEARS = 10
+	NODE_ID_SIZE             = 20
+)
+
+// NodeID identifies a node by the hash of its public key.
+func NodeID(publicKey *ecdsa.PublicKey) string {
+	h := sha256.New()
+	h.Write(publicKey.X.Bytes())
+	h.Write(publicKey.Y.Bytes())
+	return hex.EncodeToString(h.Sum(nil)[:NODE_ID_SIZE])
+}
+
+// ParseNodeIDs reads comma separated address=node_id pairs, the node IDs
+// the neighbours at those addresses must present.
+func ParseNodeIDs(s string) (map[string]string, error) {
+	ids := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		address, id, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("node id %q: expected address=node_id", pair)
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, fmt.Errorf("node id %q: %v", pair, err)
+		}
+		if b, err := hex.DecodeString(id); err != nil || len(b) != NODE_ID_SIZE {
+			return nil, fmt.Errorf("node id %q: expected %d hex encoded bytes", pair, NODE_ID_SIZE)
+		}
+		ids[address] = strings.ToLower(id)
+	}
+	return ids, nil
+}
+
+// LoadOrCreateNodeIdentity loads the node key and its self-signed
+// certificate, creating and persisting both on first run so the node keeps
+// the same identity across restarts.
+func LoadOrCreateNodeIdentity(keyPath string, certPath string) (tls.Certificate, error) {
+	key, err := loadOrCreateKey(keyPath)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if _, err := os.Stat(certPath); errors.Is(err, os.ErrNotExist) {
+		der, err := selfSignedCertificate(key)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	return tls.LoadX509KeyPair(certPath, keyPath)
+}
+
+func loadOrCreateKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(path, keyPEM, 0600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func selfSignedCertificate(key *ecdsa.PrivateKey) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: NodeID(&key.PublicKey)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(NODE_CERT_VALIDITY_YEARS, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP(GetHost())},
+	}
+	return x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+}
+
+// Fingerprint is the SHA-256 of a DER encoded certificate.
+func Fingerprint(der []byte) string {
+	h := sha256.Sum256(der)
+	return hex.EncodeToString(h[:])
+}
+
+// LoadTrustedCertificates reads the fingerprints of every PEM certificate in
+// path, which may be a single file or a directory of them.
+func LoadTrustedCertificates(path string) (map[string]bool, error) {
+	files := []string{path}
+	if info, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		files, err = filepath.Glob(filepath.Join(path, "*.crt"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	trusted := make(map[string]bool)
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type == "CERTIFICATE" {
+				trusted[Fingerprint(block.Bytes)] = true
+			}
+		}
+	}
+	if len(trusted) == 0 {
+		return nil, fmt.Errorf("%s: no certificates found", path)
+	}
+	return trusted, nil
+}
+
+// verifyPinned accepts a peer only if its certificate is one of the trusted
+// ones. Node certificates are self-signed, so there is no chain to verify.
+func verifyPinned(trusted map[string]bool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("tls: peer presented no certificate")
+		}
+		if !trusted[Fingerprint(rawCerts[0])] {
+			return fmt.Errorf("tls: untrusted certificate %s", Fingerprint(rawCerts[0]))
+		}
+		return nil
+	}
+}
+
+// CertificateNodeID is the NodeID of the key a DER encoded node
+// certificate was issued for.
+func CertificateNodeID(der []byte) (string, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return "", err
+	}
+	key, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return "", errors.New("tls: not a node certificate")
+	}
+	return NodeID(key), nil
+}
+
+// ServerTLSConfig serves with cert. With a trusted set, clients must present
+// one of the trusted certificates.
+func ServerTLSConfig(cert tls.Certificate, trusted map[string]bool) *tls.Config {
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if trusted != nil {
+		config.ClientAuth = tls.RequireAnyClientCert
+		config.VerifyPeerCertificate = verifyPinned(trusted)
+	}
+	return config
+}
+
+// ClientTLSConfig dials presenting cert, if any. With a trusted set the
+// server must present one of the trusted certificates; without one it is
+// verified against the system roots.
+func ClientTLSConfig(cert *tls.Certificate, trusted map[string]bool) *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	if trusted != nil {
+		// Pinned certificates may be self-signed, so verifyPinned checks
+		// them in place of the chain.
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = verifyPinned(trusted)
+	}
+	return config
+}
+
+// NodeServerTLSConfig serves neighbours. Without a trusted set any client
+// certificate is still asked for, so that the node ID in it can be matched
+// against the neighbour the client claims to be.
+func NodeServerTLSConfig(cert tls.Certificate, trusted map[string]bool) *tls.Config {
+	config := ServerTLSConfig(cert, trusted)
+	if trusted == nil {
+		config.ClientAuth = tls.RequestClientCert
+	}
+	return config
+}
+
+// NodeClientTLSConfig dials neighbours presenting cert. Node certificates
+// are self-signed, so they are checked against the trusted set if there is
+// one, and otherwise by the caller matching the node ID in them to the one
+// pinned for the neighbour, or binding it on first contact.
+func NodeClientTLSConfig(cert *tls.Certificate, trusted map[string]bool) *tls.Config {
+	config := ClientTLSConfig(cert, trusted)
+	config.InsecureSkipVerify = true
+	return config
+}
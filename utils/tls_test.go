@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"crypto/tls"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseNodeIDs(t *testing.T) {
+	id := "00112233445566778899AABBCCDDEEFF00112233"
+	ids, err := ParseNodeIDs(" 127.0.0.1:5001=" + id + ", [::1]:5002=" + id + ",")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids["127.0.0.1:5001"] != "00112233445566778899aabbccddeeff00112233" || ids["[::1]:5002"] == "" {
+		t.Fatalf("parsed %v", ids)
+	}
+	if ids, err := ParseNodeIDs(""); err != nil || len(ids) != 0 {
+		t.Fatalf("empty: %v %v", ids, err)
+	}
+
+	for _, s := range []string{
+		"127.0.0.1:5001",
+		"127.0.0.1=" + id,
+		"127.0.0.1:5001=" + id[2:],
+		"127.0.0.1:5001=" + id[2:] + "zz",
+	} {
+		if _, err := ParseNodeIDs(s); err == nil {
+			t.Errorf("%q was accepted", s)
+		}
+	}
+}
+
+func testNodeCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	dir := t.TempDir()
+	cert, err := LoadOrCreateNodeIdentity(filepath.Join(dir, "node.key"), filepath.Join(dir, "node.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// handshake connects a client with clientConfig to a server with
+// serverConfig and returns the client's error.
+func handshake(clientConfig *tls.Config, serverConfig *tls.Config) error {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	go tls.Server(s, serverConfig).Handshake()
+	return tls.Client(c, clientConfig).Handshake()
+}
+
+func TestNodeClientTLSRejectsUntrustedCertificate(t *testing.T) {
+	client := testNodeCertificate(t)
+	pinned := testNodeCertificate(t)
+	impostor := testNodeCertificate(t)
+	trusted := map[string]bool{Fingerprint(pinned.Certificate[0]): true}
+	clientConfig := NodeClientTLSConfig(&client, trusted)
+
+	if err := handshake(clientConfig, NodeServerTLSConfig(pinned, nil)); err != nil {
+		t.Fatalf("the trusted certificate was refused: %v", err)
+	}
+	if err := handshake(clientConfig, NodeServerTLSConfig(impostor, nil)); err == nil {
+		t.Fatal("an untrusted certificate was accepted")
+	}
+}
@@ -0,0 +1,283 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal RFC 6455 server side WebSocket: text and binary messages,
+// fragmentation, ping/pong and the closing handshake.
+
+const (
+	WS_MAX_MESSAGE_BYTES = 64 * 1024
+
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	WsOpContinuation = 0x0
+	WsOpText         = 0x1
+	WsOpBinary       = 0x2
+	WsOpClose        = 0x8
+	WsOpPing         = 0x9
+	WsOpPong         = 0xA
+
+	WsCloseNormal        = 1000
+	WsCloseGoingAway     = 1001
+	WsCloseProtocolError = 1002
+	WsClosePolicy        = 1008
+	WsCloseTooBig        = 1009
+)
+
+var ErrWebSocketClosed = errors.New("websocket: connection closed")
+
+type WebSocket struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	wmux   sync.Mutex
+	closed bool
+}
+
+func headerContains(h http.Header, name string, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CheckOrigin tells whether the page a request came from may open a
+// WebSocket. Browsers always send an Origin, so a request without one is
+// not from a page. Otherwise the origin must be the host the request was
+// sent to, or one of allowed, where "*" allows any.
+func CheckOrigin(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpgradeWebSocket completes the opening handshake and takes over the
+// connection, refusing pages whose origin CheckOrigin does not allow. On
+// failure it has already answered the request.
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request, allowedOrigins []string) (*WebSocket, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, errors.New("websocket: bad handshake")
+	}
+	if !CheckOrigin(r, allowedOrigins) {
+		http.Error(w, "websocket origin not allowed", http.StatusForbidden)
+		return nil, fmt.Errorf("websocket: origin %q not allowed", r.Header.Get("Origin"))
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha1.Sum([]byte(key + wsGUID))
+	accept := base64.StdEncoding.EncodeToString(h[:])
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + accept + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return &WebSocket{conn: conn, reader: rw.Reader}, nil
+}
+
+func (ws *WebSocket) RemoteAddr() net.Addr {
+	return ws.conn.RemoteAddr()
+}
+
+type wsFrame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+func (ws *WebSocket) readFrame() (*wsFrame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(ws.reader, head[:]); err != nil {
+		return nil, err
+	}
+	f := &wsFrame{fin: head[0]&0x80 != 0, opcode: head[0] & 0x0F}
+	if head[0]&0x70 != 0 {
+		return nil, ws.fail(WsCloseProtocolError, "reserved bits set")
+	}
+	if head[1]&0x80 == 0 {
+		return nil, ws.fail(WsCloseProtocolError, "client frames must be masked")
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if f.opcode >= WsOpClose && (length > 125 || !f.fin) {
+		return nil, ws.fail(WsCloseProtocolError, "invalid control frame")
+	}
+	if length > WS_MAX_MESSAGE_BYTES {
+		return nil, ws.fail(WsCloseTooBig, "message too big")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.reader, mask[:]); err != nil {
+		return nil, err
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, f.payload); err != nil {
+		return nil, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return f, nil
+}
+
+// ReadMessage returns the next text or binary message, answering pings and
+// the closing handshake on the way. It returns ErrWebSocketClosed once the
+// peer has closed the connection.
+func (ws *WebSocket) ReadMessage() (byte, []byte, error) {
+	var opcode byte
+	var message []byte
+	for {
+		f, err := ws.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch f.opcode {
+		case WsOpPing:
+			if err := ws.writeFrame(WsOpPong, f.payload, time.Second*5); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case WsOpPong:
+			continue
+		case WsOpClose:
+			code := uint16(WsCloseNormal)
+			if len(f.payload) >= 2 {
+				code = binary.BigEndian.Uint16(f.payload)
+			}
+			ws.WriteClose(code, "")
+			return 0, nil, ErrWebSocketClosed
+		case WsOpText, WsOpBinary:
+			if message != nil {
+				return 0, nil, ws.fail(WsCloseProtocolError, "expected a continuation frame")
+			}
+			opcode = f.opcode
+			message = f.payload
+		case WsOpContinuation:
+			if message == nil {
+				return 0, nil, ws.fail(WsCloseProtocolError, "unexpected continuation frame")
+			}
+			if len(message)+len(f.payload) > WS_MAX_MESSAGE_BYTES {
+				return 0, nil, ws.fail(WsCloseTooBig, "message too big")
+			}
+			message = append(message, f.payload...)
+		default:
+			return 0, nil, ws.fail(WsCloseProtocolError, "unknown opcode")
+		}
+		if f.fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+func (ws *WebSocket) writeFrame(opcode byte, payload []byte, timeout time.Duration) error {
+	ws.wmux.Lock()
+	defer ws.wmux.Unlock()
+
+	if ws.closed {
+		return ErrWebSocketClosed
+	}
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	ws.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := ws.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	if opcode == WsOpClose {
+		ws.closed = true
+	}
+	return nil
+}
+
+// WriteText sends a text message, giving up after timeout so a client that
+// stops reading cannot hold the writer forever.
+func (ws *WebSocket) WriteText(data []byte, timeout time.Duration) error {
+	return ws.writeFrame(WsOpText, data, timeout)
+}
+
+func (ws *WebSocket) WritePing(timeout time.Duration) error {
+	return ws.writeFrame(WsOpPing, nil, timeout)
+}
+
+// WriteClose starts or answers the closing handshake.
+func (ws *WebSocket) WriteClose(code uint16, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	return ws.writeFrame(WsOpClose, append(payload, reason...), time.Second*5)
+}
+
+func (ws *WebSocket) fail(code uint16, reason string) error {
+	ws.WriteClose(code, reason)
+	return fmt.Errorf("websocket: %s", reason)
+}
+
+func (ws *WebSocket) Close() error {
+	return ws.conn.Close()
+}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The key and accept value of the example in RFC 6455 section 1.3.
+const (
+	testWSKey    = "dGhlIHNhbXBsZSBub25jZQ=="
+	testWSAccept = "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+)
+
+// echoServer echoes each text message until the client closes, then
+// reports what ReadMessage returned.
+func echoServer(t *testing.T, allowedOrigins []string) (*httptest.Server, chan error) {
+	t.Helper()
+	errs := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := UpgradeWebSocket(w, r, allowedOrigins)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			ws.WriteText(data, time.Second)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, errs
+}
+
+type testWSClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialWS sends the opening handshake with origin, if it is not empty, and
+// returns the response.
+func dialWS(t *testing.T, srv *httptest.Server, origin string) (*testWSClient, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "GET / HTTP/1.1\r\nHost: " + srv.Listener.Addr().String() + "\r\n" +
+		"Connection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: " + testWSKey + "\r\n"
+	if origin != "" {
+		req += "Origin: " + origin + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	c := &testWSClient{conn, bufio.NewReader(conn)}
+	resp, err := http.ReadResponse(c.reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, resp
+}
+
+// writeFrame sends a final frame, masked unless mask is nil.
+func (c *testWSClient) writeFrame(t *testing.T, opcode byte, payload []byte, mask []byte) {
+	t.Helper()
+	frame := []byte{0x80 | opcode, byte(len(payload))}
+	if mask != nil {
+		frame[1] |= 0x80
+		frame = append(frame, mask...)
+		for i, b := range payload {
+			payload[i] = b ^ mask[i%4]
+		}
+	}
+	if _, err := c.conn.Write(append(frame, payload...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testWSClient) readFrame(t *testing.T) (byte, []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("server frames must not be masked")
+	}
+	payload := make([]byte, head[1]&0x7F)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0F, payload
+}
+
+func upgraded(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusSwitchingProtocols && resp.Header.Get("Sec-WebSocket-Accept") == testWSAccept
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	srv, _ := echoServer(t, nil)
+	_, resp := dialWS(t, srv, "")
+	if !upgraded(resp) {
+		t.Fatalf("%s, Sec-WebSocket-Accept %q, want %q", resp.Status, resp.Header.Get("Sec-WebSocket-Accept"), testWSAccept)
+	}
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Fatalf("plain GET: %s", resp.Status)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	srv, _ := echoServer(t, []string{"https://explorer.example"})
+	for origin, allowed := range map[string]bool{
+		"":                                       true,
+		"http://" + srv.Listener.Addr().String(): true,
+		"https://explorer.example":               true,
+		"https://explorer.example.evil":          false,
+		"https://evil.example":                   false,
+		"null":                                   false,
+	} {
+		_, resp := dialWS(t, srv, origin)
+		if got := upgraded(resp); got != allowed {
+			t.Errorf("origin %q: %s, want allowed %v", origin, resp.Status, allowed)
+		}
+		if !allowed && resp.StatusCode != http.StatusForbidden {
+			t.Errorf("origin %q: %s, want %d", origin, resp.Status, http.StatusForbidden)
+		}
+	}
+
+	allowAll, _ := echoServer(t, []string{"*"})
+	if _, resp := dialWS(t, allowAll, "https://evil.example"); !upgraded(resp) {
+		t.Errorf("* refused an origin: %s", resp.Status)
+	}
+}
+
+func TestWebSocketMasking(t *testing.T) {
+	srv, errs := echoServer(t, nil)
+	c, _ := dialWS(t, srv, "")
+	c.writeFrame(t, WsOpText, []byte("hello"), []byte{1, 2, 3, 4})
+	if opcode, payload := c.readFrame(t); opcode != WsOpText || string(payload) != "hello" {
+		t.Fatalf("echo: opcode %d %q", opcode, payload)
+	}
+
+	c.writeFrame(t, WsOpText, []byte("unmasked"), nil)
+	opcode, payload := c.readFrame(t)
+	if opcode != WsOpClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != WsCloseProtocolError {
+		t.Fatalf("an unmasked frame: opcode %d %q, want a protocol error close", opcode, payload)
+	}
+	if err := <-errs; err == nil || !strings.Contains(err.Error(), "masked") {
+		t.Fatalf("ReadMessage returned %v", err)
+	}
+}
+
+func TestWebSocketPing(t *testing.T) {
+	srv, _ := echoServer(t, nil)
+	c, _ := dialWS(t, srv, "")
+	c.writeFrame(t, WsOpPing, []byte("are you there"), []byte{5, 6, 7, 8})
+	if opcode, payload := c.readFrame(t); opcode != WsOpPong || string(payload) != "are you there" {
+		t.Fatalf("ping: opcode %d %q, want a pong with its payload", opcode, payload)
+	}
+}
+
+func TestWebSocketClose(t *testing.T) {
+	srv, errs := echoServer(t, nil)
+	c, _ := dialWS(t, srv, "")
+	c.writeFrame(t, WsOpClose, binary.BigEndian.AppendUint16(nil, WsCloseGoingAway), []byte{9, 9, 9, 9})
+	opcode, payload := c.readFrame(t)
+	if opcode != WsOpClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != WsCloseGoingAway {
+		t.Fatalf("close: opcode %d %q, want the code echoed", opcode, payload)
+	}
+	if err := <-errs; err != ErrWebSocketClosed {
+		t.Fatalf("ReadMessage returned %v, want %v", err, ErrWebSocketClosed)
+	}
+}
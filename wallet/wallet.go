@@ -18,7 +18,9 @@ type Wallet struct {
 	blockchainAddress string
 }
 
-func NewWallet() *Wallet {
+// NewWallet creates a key pair and derives its address with the network's
+// address version byte.
+func NewWallet(addressVersion byte) *Wallet {
 	w := new(Wallet)
 	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	w.privateKey = privateKey
@@ -34,7 +36,7 @@ func NewWallet() *Wallet {
 	digest3 := h3.Sum(nil)
 
 	vd4 := make([]byte, 33) // Adjusted size from 21 to 33
-	vd4[0] = 0x00
+	vd4[0] = addressVersion
 	copy(vd4[1:], digest3[:])
 
 	h5 := sha256.New()
@@ -94,6 +96,8 @@ type Transaction struct {
 	senderBlockchainAddress		string
 	receiverBlockchainAddress	string
 	value						float64
+	fee							float64
+	nonce						uint64
 }
 
 func NewTransaction(
@@ -102,17 +106,19 @@ func NewTransaction(
 	sender string,
 	receiver string,
 	value float64,
+	fee float64,
+	nonce uint64,
 ) *Transaction {
 	return &Transaction{
-		privateKey, publicKey, sender, receiver, value,
+		privateKey, publicKey, sender, receiver, value, fee, nonce,
 	}
 } 
 
-func (t *Transaction) GenerateSignature() *utils.Signature {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
+// GenerateSignature signs the transaction for the network with chainID.
+func (t *Transaction) GenerateSignature(chainID uint32) *utils.Signature {
+	h := utils.TransactionSigningHash(chainID, t.senderBlockchainAddress, t.receiverBlockchainAddress, t.value, t.fee, t.nonce)
 	r, s, _ := ecdsa.Sign(rand.Reader, t.senderPrivateKey, h[:])
-	return &utils.Signature{r, s}
+	return utils.NewSignature(r, s)
 }
 
 func (t *Transaction) MarshalJSON() ([]byte, error) {
@@ -120,10 +126,14 @@ func (t *Transaction) MarshalJSON() ([]byte, error) {
 		Sender		string	`json:"sender_address"`
 		Receiver	string	`json:"receiver_address"`
 		Value		float64	`json:"value"`
+		Fee			float64	`json:"fee,omitempty"`
+		Nonce		uint64	`json:"nonce"`
 	}{
 		Sender: t.senderBlockchainAddress,
 		Receiver: t.receiverBlockchainAddress,
 		Value: t.value,
+		Fee: t.fee,
+		Nonce: t.nonce,
 	})
 }
 
@@ -133,6 +143,8 @@ type TransactionRequest struct {
 	ReceiverBlockchainAddress	*string `json:"receiver_blockchain_address"`
 	SenderPublicKey 			*string `json:"sender_public_key"`
 	Value						*string `json:"value"`
+	Fee							*string `json:"fee"`
+	Nonce						*string `json:"nonce"`
 }
 
 func (tr *TransactionRequest) Validate() (bool, string) {
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
+	"time"
+
+	"github.com/palmcivet7/go-blockchain/block"
+	"github.com/palmcivet7/go-blockchain/utils"
 )
 
 func init() {
@@ -11,9 +18,56 @@ func init() {
 
 func main() {
 	port := flag.Uint("port", 8080, "TCP Port Number for Wallet Server")
-	gateway := flag.String("gateway", "http://127.0.0.1:5000", "Blockchain Gateway")
+	network := flag.String("network", block.MainnetParams.Name, fmt.Sprintf("Network to create wallets for, one of %v", block.NetworkNames()))
+	gateway := flag.String("gateway", "", "Blockchain Gateway, defaults to the local node of the network")
+	tlsCert := flag.String("tls_cert", "", "Certificate to serve the wallet over TLS with")
+	tlsKey := flag.String("tls_key", "", "Private key for -tls_cert")
+	gatewayCert := flag.String("gateway_cert", "", "Pin the certificate of an https gateway")
+	clientCert := flag.String("client_cert", "", "Certificate to present to a gateway that requires mutual TLS")
+	clientKey := flag.String("client_key", "", "Private key for -client_cert")
+	rateLimit := flag.Float64("rate_limit", utils.RATE_LIMIT_PER_SEC, "Requests a second allowed from one IP, 0 for no limit")
+	rateBurst := flag.Int("rate_burst", utils.RATE_LIMIT_BURST, "Requests one IP may make at once before -rate_limit applies")
+	maxBodyBytes := flag.Int64("max_body_bytes", utils.MAX_BODY_BYTES, "Largest request body accepted, 0 for no limit")
 	flag.Parse()
 
-	app := NewWalletServer(uint16(*port), *gateway)
+	params, err := block.ParamsForNetwork(*network)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *gateway == "" {
+		*gateway = fmt.Sprintf("http://127.0.0.1:%d", params.DefaultPort)
+	}
+
+	var serverTLS *tls.Config
+	if *tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		serverTLS = utils.ServerTLSConfig(cert, nil)
+	}
+
+	var trusted map[string]bool
+	if *gatewayCert != "" {
+		trusted, err = utils.LoadTrustedCertificates(*gatewayCert)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	var cert *tls.Certificate
+	if *clientCert != "" {
+		c, err := tls.LoadX509KeyPair(*clientCert, *clientKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cert = &c
+	}
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: utils.ClientTLSConfig(cert, trusted)},
+	}
+
+	limiter := utils.NewLimiter(*rateLimit, *rateBurst, *maxBodyBytes)
+	app := NewWalletServer(uint16(*port), *gateway, params, client, serverTLS, limiter)
 	app.Run( )
-}
\ No newline at end of file
+}
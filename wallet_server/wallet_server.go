@@ -2,11 +2,14 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"path"
 	"strconv"
 	"text/template"
@@ -21,10 +24,17 @@ const tempDir = "templates"
 type WalletServer struct {
 	port		uint16
 	gateway		string
+	params		*block.ChainParams
+	client		*http.Client
+	tlsConfig	*tls.Config
+	limiter		*utils.Limiter
+	metrics		*utils.Registry
 }
 
-func NewWalletServer(port uint16, gateway string) *WalletServer {
-	return &WalletServer{port, gateway}
+// NewWalletServer serves over TLS when tlsConfig is set, reaches the
+// gateway through client and throttles clients with limiter.
+func NewWalletServer(port uint16, gateway string, params *block.ChainParams, client *http.Client, tlsConfig *tls.Config, limiter *utils.Limiter) *WalletServer {
+	return &WalletServer{port, gateway, params, client, tlsConfig, limiter, utils.NewRegistry()}
 }
 
 func (ws *WalletServer) Port() uint16 {
@@ -51,109 +61,198 @@ func (ws *WalletServer) Index(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	default:
-		log.Printf("ERROR: Invalid HTTP Method")
+		utils.MethodNotAllowed(w, http.MethodGet)
 	}
 }
 
 func (ws *WalletServer) Wallet(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-		w.Header().Add("Content-Type", "application-json")
-		myWallet := wallet.NewWallet()
+		w.Header().Add("Content-Type", "application/json")
+		myWallet := wallet.NewWallet(ws.params.AddressVersion)
 		m, _ := myWallet.MarshalJSON()
 		io.WriteString(w, string(m[:]))
 	default:
-		w.WriteHeader(http.StatusBadRequest)
-		log.Println("ERROR: Invalid HTTP Method")
+		utils.MethodNotAllowed(w, http.MethodPost)
 	}
 }
 
+// signTransaction signs the transaction described by a wallet transaction
+// request, looking up the sender's next nonce when none is given.
+func (ws *WalletServer) signTransaction(r *http.Request) (*block.TransactionRequest, *utils.APIError) {
+	decoder := json.NewDecoder(r.Body)
+	var t wallet.TransactionRequest
+	err := decoder.Decode(&t)
+	if err != nil {
+		return nil, utils.DecodeError(err)
+	}
+	isValid, errorMsg := t.Validate()
+	if !isValid {
+		return nil, utils.BadRequest(utils.ERR_CODE_MISSING_FIELD, errorMsg)
+	}
+
+	publicKey, err := utils.PublicKeyFromString(*t.SenderPublicKey)
+	if err != nil {
+		return nil, utils.BadRequest(utils.ERR_CODE_BAD_PUBLIC_KEY, err.Error())
+	}
+	privateKey, err := utils.PrivateKeyFromString(*t.SenderPrivateKey, publicKey)
+	if err != nil {
+		return nil, utils.BadRequest(utils.ERR_CODE_BAD_PRIVATE_KEY, err.Error())
+	}
+	value, err := strconv.ParseFloat(*t.Value, 32)
+	if err != nil {
+		return nil, utils.BadRequest(utils.ERR_CODE_INVALID_PARAMETER, "invalid value")
+	}
+	value64 := float64(value)
+	var fee64 float64
+	if t.Fee != nil && *t.Fee != "" {
+		fee64, err = strconv.ParseFloat(*t.Fee, 64)
+		if err != nil {
+			return nil, utils.BadRequest(utils.ERR_CODE_INVALID_PARAMETER, "invalid fee")
+		}
+	}
+
+	// Without a nonce the transaction goes after the sender's others,
+	// with a pending one it replaces that transaction.
+	var nonce uint64
+	if t.Nonce != nil && *t.Nonce != "" {
+		if nonce, err = strconv.ParseUint(*t.Nonce, 10, 64); err != nil {
+			return nil, utils.BadRequest(utils.ERR_CODE_INVALID_PARAMETER, "invalid nonce")
+		}
+	} else if nonce, err = ws.nextNonce(*t.SenderBlockchainAddress); err != nil {
+		return nil, utils.NewAPIError(http.StatusBadGateway, utils.ERR_CODE_GATEWAY_ERROR, err.Error())
+	}
+
+	transaction := wallet.NewTransaction(privateKey, publicKey,
+		*t.SenderBlockchainAddress, *t.ReceiverBlockchainAddress, value64, fee64, nonce)
+	signature := transaction.GenerateSignature(ws.params.ChainID)
+	signatureStr := signature.String()
+
+	return &block.TransactionRequest{
+		SenderAddress: t.SenderBlockchainAddress,
+		ReceiverAddress: t.ReceiverBlockchainAddress,
+		SenderPublicKey: t.SenderPublicKey,
+		Value: &value64,
+		Fee: &fee64,
+		Nonce: &nonce,
+		Signature: &signatureStr,
+	}, nil
+}
+
 func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, r *http.Request) {
 	switch r.Method{
 	case http.MethodPost:
-		decoder := json.NewDecoder(r.Body)
-		var t wallet.TransactionRequest
-		err := decoder.Decode(&t)
+		bt, apiErr := ws.signTransaction(r)
+		if apiErr != nil {
+			log.Printf("ERROR: %v", apiErr)
+			utils.WriteError(w, apiErr)
+			return
+		}
+
+		m, _ := json.Marshal(bt)
+		buf := bytes.NewBuffer(m)
+
+		resp, err := ws.client.Post(ws.Gateway() + utils.API_V1_PREFIX + "/transactions", "application/json", buf)
 		if err != nil {
 			log.Printf("ERROR: %v", err)
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+			utils.WriteError(w, utils.NewAPIError(http.StatusBadGateway, utils.ERR_CODE_GATEWAY_ERROR, err.Error()))
 			return
 		}
-		isValid, errorMsg := t.Validate()
-		if !isValid {
-			log.Printf("ERROR: %s", errorMsg)
-			io.WriteString(w, string(utils.JsonStatus(errorMsg)))
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			// Pass on why the node refused the transaction.
+			utils.WriteError(w, utils.ReadAPIError(resp))
 			return
 		}
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, string(utils.JsonStatus("success")))
+		 
+	default:
+		utils.MethodNotAllowed(w, http.MethodPost)
+	}
+}
 
-		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		privateKey := utils.PrivateKeyFromString(*t.SenderPrivateKey, publicKey)
-		value, err := strconv.ParseFloat(*t.Value, 32)
-		if err != nil {
-			log.Println("ERROR: parse error ")
-			io.WriteString(w, string(utils.JsonStatus(errorMsg)))
+// RawTransaction signs a transaction without sending it and returns it in
+// the raw transaction format, for POST /transactions/raw on any node.
+func (ws *WalletServer) RawTransaction(w http.ResponseWriter, r *http.Request) {
+	switch r.Method{
+	case http.MethodPost:
+		bt, apiErr := ws.signTransaction(r)
+		if apiErr != nil {
+			log.Printf("ERROR: %v", apiErr)
+			utils.WriteError(w, apiErr)
 			return
 		}
-		value64 := float64(value)
-
-		w.Header().Add("Content-Type", "application-json")
 
-		transaction := wallet.NewTransaction(privateKey, publicKey,
-			*t.SenderBlockchainAddress, *t.ReceiverBlockchainAddress, value64)
-		signature := transaction.GenerateSignature()
-		signatureStr := signature.String()
-
-		bt := &block.TransactionRequest{
-			SenderAddress: t.SenderBlockchainAddress,
-			ReceiverAddress: t.ReceiverBlockchainAddress,
-			SenderPublicKey: t.SenderPublicKey,
-			Value: &value64,
-			Signature: &signatureStr,
-		}
-		
-		m, _ := json.Marshal(bt)
-		buf := bytes.NewBuffer(m)
-
-		resp, _ := http.Post(ws.Gateway() + "/transactions", "application/json", buf)
-		if resp.StatusCode == 201 {
-			io.WriteString(w, string(utils.JsonStatus("success")))
+		raw, err := bt.RawTransaction()
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			utils.WriteError(w, err)
 			return
 		}
-		io.WriteString(w, string(utils.JsonStatus("fail")))
-		 
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(struct{
+			RawTransaction	string	`json:"raw_transaction"`
+		}{
+			RawTransaction: hex.EncodeToString(raw),
+		})
+		io.WriteString(w, string(m[:]))
+
 	default:
-		w.WriteHeader(http.StatusBadRequest)
-		log.Println("ERROR: Invalid HTTP Method")
+		utils.MethodNotAllowed(w, http.MethodPost)
+	}
+}
+
+// nextNonce asks the gateway for the nonce of the sender's next transaction.
+func (ws *WalletServer) nextNonce(blockchainAddress string) (uint64, error) {
+	endpoint := fmt.Sprintf("%s%s/nonce?blockchain_address=%s", ws.Gateway(), utils.API_V1_PREFIX, url.QueryEscape(blockchainAddress))
+	resp, err := ws.client.Get(endpoint)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("nonce request failed with status %d", resp.StatusCode)
 	}
+	var v struct{
+		Nonce	*uint64	`json:"nonce"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return 0, err
+	}
+	if v.Nonce == nil {
+		return 0, fmt.Errorf("nonce response is missing the nonce")
+	}
+	return *v.Nonce, nil
 }
 
 func (ws *WalletServer) WalletAmount(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		blockchainAddress := r.URL.Query().Get("blockchain_address")
-		endpoint := fmt.Sprintf("%s/amount", ws.Gateway())
+		endpoint := fmt.Sprintf("%s%s/amount", ws.Gateway(), utils.API_V1_PREFIX)
 
-		client := &http.Client{}
 		bcsReq, _ := http.NewRequest("GET", endpoint, nil)
 		q := bcsReq.URL.Query()
 		q.Add("blockchain_address", blockchainAddress)
 		bcsReq.URL.RawQuery = q.Encode()
 
-		bcsResp, err := client.Do(bcsReq)
+		bcsResp, err := ws.client.Do(bcsReq)
 		if err != nil {
 			log.Printf("ERROR: %v", err)
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+			utils.WriteError(w, utils.NewAPIError(http.StatusBadGateway, utils.ERR_CODE_GATEWAY_ERROR, err.Error()))
 			return
 		}
+		defer bcsResp.Body.Close()
 
-		w.Header().Add("Content-Type", "application-json")
 		if bcsResp.StatusCode == 200 {
 			decoder := json.NewDecoder(bcsResp.Body)
 			var bar block.AmountResponse
 			err := decoder.Decode(&bar)
 			if err != nil {
 				log.Printf("ERROR: %v", err)
-				io.WriteString(w, string(utils.JsonStatus("fail")))
+				utils.WriteError(w, utils.NewAPIError(http.StatusBadGateway, utils.ERR_CODE_GATEWAY_ERROR, err.Error()))
 				return
 			}
 
@@ -164,21 +263,84 @@ func (ws *WalletServer) WalletAmount(w http.ResponseWriter, r *http.Request) {
 				Message: "success",
 				Amount: bar.Amount,
 			})
+			w.Header().Add("Content-Type", "application/json")
 			io.WriteString(w, string(m[:])) 
 		} else {
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+			utils.WriteError(w, utils.ReadAPIError(bcsResp))
+		}
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet)
+	}
+}
+
+// WalletTransactions relays the transaction history of blockchain_address
+// from the gateway, passing direction, offset and limit through.
+func (ws *WalletServer) WalletTransactions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		blockchainAddress := r.URL.Query().Get("blockchain_address")
+		if blockchainAddress == "" {
+			utils.WriteError(w, utils.BadRequest(utils.ERR_CODE_MISSING_FIELD, "missing blockchain_address"))
+			return
+		}
+		endpoint := fmt.Sprintf("%s%s/address/%s/transactions", ws.Gateway(), utils.API_V1_PREFIX, url.PathEscape(blockchainAddress))
+
+		bcsReq, _ := http.NewRequest("GET", endpoint, nil)
+		q := bcsReq.URL.Query()
+		for _, name := range []string{"direction", "offset", "limit"} {
+			if v := r.URL.Query().Get(name); v != "" {
+				q.Add(name, v)
+			}
+		}
+		bcsReq.URL.RawQuery = q.Encode()
+
+		bcsResp, err := ws.client.Do(bcsReq)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			utils.WriteError(w, utils.NewAPIError(http.StatusBadGateway, utils.ERR_CODE_GATEWAY_ERROR, err.Error()))
+			return
 		}
+		defer bcsResp.Body.Close()
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(bcsResp.StatusCode)
+		io.Copy(w, bcsResp.Body)
 	default:
-		log.Printf("ERROR: Invalid HTTP Method")
-		w.WriteHeader(http.StatusBadRequest)
+		utils.MethodNotAllowed(w, http.MethodGet)
 	}
 }
 
+// Limits serves what the rate, body size and concurrency limits have done
+// to each endpoint.
+func (ws *WalletServer) Limits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		m, _ := json.Marshal(ws.limiter.Stats())
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		utils.MethodNotAllowed(w, http.MethodGet)
+	}
+}
+
+func (ws *WalletServer) handle(pattern string, h http.HandlerFunc) {
+	http.HandleFunc(pattern, ws.metrics.Instrument(pattern, ws.limiter.Limit(pattern, utils.MAX_CONCURRENT_REQUESTS, h)))
+}
 
 func (ws *WalletServer) Run() {
-	http.HandleFunc("/", ws.Index)
-	http.HandleFunc("/wallet", ws.Wallet)
-	http.HandleFunc("/wallet/amount", ws.WalletAmount)
-	http.HandleFunc("/transaction", ws.CreateTransaction)
-	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(ws.Port())), nil))
+	ws.handle("/", ws.Index)
+	ws.handle("/wallet", ws.Wallet)
+	ws.handle("/wallet/amount", ws.WalletAmount)
+	ws.handle("/wallet/transactions", ws.WalletTransactions)
+	ws.handle("/transaction", ws.CreateTransaction)
+	ws.handle("/transaction/raw", ws.RawTransaction)
+	http.HandleFunc("/limits", ws.Limits)
+	ws.limiter.RegisterMetrics(ws.metrics, "http_limiter")
+	http.HandleFunc("/metrics", ws.metrics.Metrics)
+	address := "0.0.0.0:"+strconv.Itoa(int(ws.Port()))
+	if ws.tlsConfig != nil {
+		server := &http.Server{Addr: address, TLSConfig: ws.tlsConfig}
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
+	log.Fatal(http.ListenAndServe(address, nil))
 }
\ No newline at end of file
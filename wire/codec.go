@@ -0,0 +1,199 @@
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Every message on the wire is framed as
+//
+//	magic (4 bytes) | command (1 byte) | payload length (4 bytes) | payload
+//
+// with all integers in big-endian order.
+const (
+	MAGIC            = 0x676f6263 // "gobc"
+	HEADER_SIZE      = 9
+	MAX_PAYLOAD_SIZE = 32 * 1024 * 1024
+)
+
+var (
+	ErrBadMagic        = errors.New("wire: bad magic")
+	ErrPayloadTooLarge = errors.New("wire: payload too large")
+	ErrUnknownCommand  = errors.New("wire: unknown command")
+	ErrNonFiniteFloat  = errors.New("wire: float is NaN or infinite")
+)
+
+// WriteMessage frames and writes a single message.
+func WriteMessage(w io.Writer, msg Message) error {
+	var payload bytes.Buffer
+	msg.encode(&encoder{&payload})
+	if payload.Len() > MAX_PAYLOAD_SIZE {
+		return ErrPayloadTooLarge
+	}
+	header := make([]byte, HEADER_SIZE)
+	binary.BigEndian.PutUint32(header[0:4], MAGIC)
+	header[4] = byte(msg.Command())
+	binary.BigEndian.PutUint32(header[5:9], uint32(payload.Len()))
+	if _, err := w.Write(append(header, payload.Bytes()...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReadMessage reads and decodes the next message.
+func ReadMessage(r *bufio.Reader) (Message, error) {
+	header := make([]byte, HEADER_SIZE)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(header[0:4]) != MAGIC {
+		return nil, ErrBadMagic
+	}
+	length := binary.BigEndian.Uint32(header[5:9])
+	if length > MAX_PAYLOAD_SIZE {
+		return nil, ErrPayloadTooLarge
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	msg := newMessage(Command(header[4]))
+	if msg == nil {
+		return nil, ErrUnknownCommand
+	}
+	d := &decoder{payload: payload}
+	msg.decode(d)
+	if d.err != nil {
+		return nil, fmt.Errorf("wire: decoding %s: %w", msg.Command(), d.err)
+	}
+	if len(d.payload) != 0 {
+		return nil, fmt.Errorf("wire: %d trailing bytes after %s", len(d.payload), msg.Command())
+	}
+	return msg, nil
+}
+
+type encoder struct {
+	buf *bytes.Buffer
+}
+
+func (e *encoder) uint8(v uint8) {
+	e.buf.WriteByte(v)
+}
+
+func (e *encoder) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) uint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) int64(v int64) {
+	e.uint64(uint64(v))
+}
+
+func (e *encoder) float64(v float64) {
+	e.uint64(math.Float64bits(v))
+}
+
+func (e *encoder) hash(h [32]byte) {
+	e.buf.Write(h[:])
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint32(uint32(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+// decoder reads fields off the payload, remembering the first error so that
+// decode methods do not need to check every field.
+type decoder struct {
+	payload []byte
+	err     error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return make([]byte, n)
+	}
+	if len(d.payload) < n {
+		d.err = io.ErrUnexpectedEOF
+		return make([]byte, n)
+	}
+	b := d.payload[:n]
+	d.payload = d.payload[n:]
+	return b
+}
+
+func (d *decoder) uint8() uint8 {
+	return d.next(1)[0]
+}
+
+func (d *decoder) uint32() uint32 {
+	return binary.BigEndian.Uint32(d.next(4))
+}
+
+func (d *decoder) uint64() uint64 {
+	return binary.BigEndian.Uint64(d.next(8))
+}
+
+func (d *decoder) int64() int64 {
+	return int64(d.uint64())
+}
+
+// float64 refuses NaN and the infinities, which no amount can be and which
+// would poison every balance they are added to.
+func (d *decoder) float64() float64 {
+	v := math.Float64frombits(d.uint64())
+	if d.err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		d.err = ErrNonFiniteFloat
+		return 0
+	}
+	return v
+}
+
+func (d *decoder) hash() [32]byte {
+	var h [32]byte
+	copy(h[:], d.next(32))
+	return h
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	if d.err == nil && int(n) > len(d.payload) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, d.next(int(n)))
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// count reads a list length, rejecting lengths that cannot possibly fit in
+// the rest of the payload given each element is at least min bytes.
+func (d *decoder) count(min int) int {
+	n := int(d.uint32())
+	if d.err == nil && n*min > len(d.payload) {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	return n
+}
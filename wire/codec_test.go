@@ -0,0 +1,175 @@
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testTransaction() Transaction {
+	return Transaction{
+		Sender:    "alice",
+		Receiver:  "bob",
+		Value:     1.5,
+		Fee:       0.25,
+		Nonce:     7,
+		PublicKey: bytes.Repeat([]byte{1}, RAW_TRANSACTION_KEY_SIZE),
+		Signature: bytes.Repeat([]byte{2}, RAW_TRANSACTION_SIG_SIZE),
+	}
+}
+
+func testBlock() Block {
+	return Block{
+		Height:       3,
+		Timestamp:    -1,
+		Nonce:        42,
+		PreviousHash: [32]byte{9},
+		Transactions: []Transaction{testTransaction(), {Sender: "miner", Receiver: "carol", Value: 100, PublicKey: []byte{}, Signature: []byte{}}},
+		ExtraData:    []byte("extra"),
+	}
+}
+
+func frame(msg Message) []byte {
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, msg); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func readFrame(b []byte) (Message, error) {
+	return ReadMessage(bufio.NewReader(bytes.NewReader(b)))
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	items := []InvItem{{InvTypeTx, [32]byte{1}}, {InvTypeBlock, [32]byte{2}}}
+	messages := []Message{
+		&Version{ProtocolVersion: PROTOCOL_VERSION, ChainID: 1337, Address: "127.0.0.1:9000", Receiver: "127.0.0.1:9001", Height: 12},
+		&Ping{Nonce: 1},
+		&Pong{Nonce: math.MaxUint64},
+		&Inv{Items: items},
+		&GetData{Items: items},
+		&GetHeaders{RequestID: 1, From: 2, Limit: 3},
+		&Headers{RequestID: 4, Height: 5, Headers: []Header{
+			{Height: 1, Timestamp: 2, Nonce: 3, PreviousHash: [32]byte{4}, TransactionsHash: [32]byte{5}, ExtraData: []byte{}},
+			{Height: 2, ExtraData: []byte("x")},
+		}},
+		&GetBlocks{RequestID: 6, From: 7, Count: 8},
+		&Blocks{RequestID: 9, Blocks: []Block{testBlock(), {Transactions: []Transaction{}, ExtraData: []byte{}}}},
+		func() Message { b := testBlock(); return &b }(),
+		&Tx{testTransaction()},
+	}
+	seen := make(map[Command]bool)
+	for _, msg := range messages {
+		got, err := readFrame(frame(msg))
+		if err != nil {
+			t.Errorf("%s: %v", msg.Command(), err)
+			continue
+		}
+		if !reflect.DeepEqual(got, msg) {
+			t.Errorf("%s: decoded %+v, want %+v", msg.Command(), got, msg)
+		}
+		seen[msg.Command()] = true
+	}
+	for c := CmdVersion; c <= CmdTx; c++ {
+		if !seen[c] {
+			t.Errorf("%s is not round tripped", c)
+		}
+	}
+}
+
+func TestRawTransactionRoundTrip(t *testing.T) {
+	tx := testTransaction()
+	got, err := DecodeRawTransactionHex("0x" + EncodeRawTransactionHex(&tx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, &tx) {
+		t.Fatalf("decoded %+v, want %+v", got, &tx)
+	}
+}
+
+func TestRawTransactionMaxSize(t *testing.T) {
+	tx := testTransaction()
+	tx.Sender = strings.Repeat("s", RAW_TRANSACTION_MAX_ADDRESS_SIZE)
+	tx.Receiver = strings.Repeat("r", RAW_TRANSACTION_MAX_ADDRESS_SIZE)
+	raw := EncodeRawTransaction(&tx)
+	if len(raw) != RAW_TRANSACTION_MAX_SIZE {
+		t.Fatalf("largest raw transaction is %d bytes, want %d", len(raw), RAW_TRANSACTION_MAX_SIZE)
+	}
+	if _, err := DecodeRawTransaction(raw); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadMessageRejectsMalformedFrames(t *testing.T) {
+	valid := frame(&Tx{testTransaction()})
+	withLength := func(n uint32) []byte {
+		b := append([]byte{}, valid...)
+		binary.BigEndian.PutUint32(b[5:9], n)
+		return b
+	}
+	nonFinite := testTransaction()
+	nonFinite.Value = math.NaN()
+
+	for _, c := range []struct {
+		name  string
+		frame []byte
+		err   error
+	}{
+		{"bad magic", append([]byte{'x'}, valid[1:]...), ErrBadMagic},
+		{"length over the limit", withLength(MAX_PAYLOAD_SIZE + 1), ErrPayloadTooLarge},
+		{"truncated header", valid[:HEADER_SIZE-1], io.ErrUnexpectedEOF},
+		{"truncated payload", valid[:len(valid)-1], io.ErrUnexpectedEOF},
+		{"short length", withLength(uint32(len(valid) - HEADER_SIZE - 1)), io.ErrUnexpectedEOF},
+		{"unknown command", append(append([]byte{}, valid[:4]...), append([]byte{0xff}, valid[5:]...)...), ErrUnknownCommand},
+		{"non-finite float", frame(&Tx{nonFinite}), ErrNonFiniteFloat},
+	} {
+		if _, err := readFrame(c.frame); !errors.Is(err, c.err) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.err)
+		}
+	}
+
+	trailing := withLength(uint32(len(valid) - HEADER_SIZE + 1))
+	if _, err := readFrame(append(trailing, 0)); err == nil {
+		t.Error("trailing bytes: frame was accepted")
+	}
+}
+
+func TestDecodeRawTransactionRejectsMalformed(t *testing.T) {
+	tx := testTransaction()
+	raw := EncodeRawTransaction(&tx)
+	short := testTransaction()
+	short.PublicKey = short.PublicKey[1:]
+
+	for _, c := range []struct {
+		name string
+		raw  []byte
+		err  error
+	}{
+		{"version", append([]byte{RAW_TRANSACTION_VERSION + 1}, raw[1:]...), ErrRawTransactionVersion},
+		{"truncated", raw[:len(raw)-1], io.ErrUnexpectedEOF},
+		{"short key", EncodeRawTransaction(&short), ErrRawTransactionKey},
+	} {
+		if _, err := DecodeRawTransaction(c.raw); !errors.Is(err, c.err) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.err)
+		}
+	}
+	long := testTransaction()
+	long.Receiver = strings.Repeat("x", RAW_TRANSACTION_MAX_ADDRESS_SIZE+1)
+	if _, err := DecodeRawTransaction(EncodeRawTransaction(&long)); err != ErrRawTransactionAddress {
+		t.Errorf("long address: got %v, want %v", err, ErrRawTransactionAddress)
+	}
+	if _, err := DecodeRawTransaction(append(raw, 0)); err == nil {
+		t.Error("trailing bytes: raw transaction was accepted")
+	}
+	if _, err := DecodeRawTransactionHex("zz"); err == nil {
+		t.Error("non-hex raw transaction was accepted")
+	}
+}
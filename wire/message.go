@@ -0,0 +1,364 @@
+package wire
+
+const PROTOCOL_VERSION = 5
+
+type Command uint8
+
+const (
+	CmdVersion Command = iota + 1
+	CmdPing
+	CmdPong
+	CmdInv
+	CmdGetData
+	CmdGetHeaders
+	CmdHeaders
+	CmdGetBlocks
+	CmdBlocks
+	CmdBlock
+	CmdTx
+)
+
+func (c Command) String() string {
+	switch c {
+	case CmdVersion:
+		return "version"
+	case CmdPing:
+		return "ping"
+	case CmdPong:
+		return "pong"
+	case CmdInv:
+		return "inv"
+	case CmdGetData:
+		return "getdata"
+	case CmdGetHeaders:
+		return "getheaders"
+	case CmdHeaders:
+		return "headers"
+	case CmdGetBlocks:
+		return "getblocks"
+	case CmdBlocks:
+		return "blocks"
+	case CmdBlock:
+		return "block"
+	case CmdTx:
+		return "tx"
+	}
+	return "unknown"
+}
+
+type Message interface {
+	Command() Command
+	encode(e *encoder)
+	decode(d *decoder)
+}
+
+func newMessage(c Command) Message {
+	switch c {
+	case CmdVersion:
+		return &Version{}
+	case CmdPing:
+		return &Ping{}
+	case CmdPong:
+		return &Pong{}
+	case CmdInv:
+		return &Inv{}
+	case CmdGetData:
+		return &GetData{}
+	case CmdGetHeaders:
+		return &GetHeaders{}
+	case CmdHeaders:
+		return &Headers{}
+	case CmdGetBlocks:
+		return &GetBlocks{}
+	case CmdBlocks:
+		return &Blocks{}
+	case CmdBlock:
+		return &Block{}
+	case CmdTx:
+		return &Tx{}
+	}
+	return nil
+}
+
+// Version opens a connection. Address is the HTTP address the sender is
+// known by as a neighbour, so both protocols share one peer identity.
+// Receiver is the address the dialing side expects to reach, and is empty
+// when sent by the accepting side.
+type Version struct {
+	ProtocolVersion uint32
+	ChainID         uint32
+	Address         string
+	Receiver        string
+	Height          uint64
+}
+
+func (m *Version) Command() Command { return CmdVersion }
+
+func (m *Version) encode(e *encoder) {
+	e.uint32(m.ProtocolVersion)
+	e.uint32(m.ChainID)
+	e.string(m.Address)
+	e.string(m.Receiver)
+	e.uint64(m.Height)
+}
+
+func (m *Version) decode(d *decoder) {
+	m.ProtocolVersion = d.uint32()
+	m.ChainID = d.uint32()
+	m.Address = d.string()
+	m.Receiver = d.string()
+	m.Height = d.uint64()
+}
+
+type Ping struct {
+	Nonce uint64
+}
+
+func (m *Ping) Command() Command  { return CmdPing }
+func (m *Ping) encode(e *encoder) { e.uint64(m.Nonce) }
+func (m *Ping) decode(d *decoder) { m.Nonce = d.uint64() }
+
+type Pong struct {
+	Nonce uint64
+}
+
+func (m *Pong) Command() Command  { return CmdPong }
+func (m *Pong) encode(e *encoder) { e.uint64(m.Nonce) }
+func (m *Pong) decode(d *decoder) { m.Nonce = d.uint64() }
+
+const (
+	InvTypeTx    uint8 = 1
+	InvTypeBlock uint8 = 2
+)
+
+type InvItem struct {
+	Type uint8
+	Hash [32]byte
+}
+
+func encodeItems(e *encoder, items []InvItem) {
+	e.uint32(uint32(len(items)))
+	for _, item := range items {
+		e.uint8(item.Type)
+		e.hash(item.Hash)
+	}
+}
+
+func decodeItems(d *decoder) []InvItem {
+	items := make([]InvItem, d.count(33))
+	for i := range items {
+		items[i].Type = d.uint8()
+		items[i].Hash = d.hash()
+	}
+	return items
+}
+
+type Inv struct {
+	Items []InvItem
+}
+
+func (m *Inv) Command() Command  { return CmdInv }
+func (m *Inv) encode(e *encoder) { encodeItems(e, m.Items) }
+func (m *Inv) decode(d *decoder) { m.Items = decodeItems(d) }
+
+type GetData struct {
+	Items []InvItem
+}
+
+func (m *GetData) Command() Command  { return CmdGetData }
+func (m *GetData) encode(e *encoder) { encodeItems(e, m.Items) }
+func (m *GetData) decode(d *decoder) { m.Items = decodeItems(d) }
+
+type Header struct {
+	Height           uint64
+	Timestamp        int64
+	Nonce            int64
+	PreviousHash     [32]byte
+	TransactionsHash [32]byte
+	ExtraData        []byte
+}
+
+func (h *Header) encode(e *encoder) {
+	e.uint64(h.Height)
+	e.int64(h.Timestamp)
+	e.int64(h.Nonce)
+	e.hash(h.PreviousHash)
+	e.hash(h.TransactionsHash)
+	e.bytes(h.ExtraData)
+}
+
+func (h *Header) decode(d *decoder) {
+	h.Height = d.uint64()
+	h.Timestamp = d.int64()
+	h.Nonce = d.int64()
+	h.PreviousHash = d.hash()
+	h.TransactionsHash = d.hash()
+	h.ExtraData = d.bytes()
+}
+
+// GetHeaders and GetBlocks carry a request ID which is echoed in the
+// response, so replies can be matched while gossip shares the connection.
+type GetHeaders struct {
+	RequestID uint32
+	From      uint64
+	Limit     uint32
+}
+
+func (m *GetHeaders) Command() Command { return CmdGetHeaders }
+
+func (m *GetHeaders) encode(e *encoder) {
+	e.uint32(m.RequestID)
+	e.uint64(m.From)
+	e.uint32(m.Limit)
+}
+
+func (m *GetHeaders) decode(d *decoder) {
+	m.RequestID = d.uint32()
+	m.From = d.uint64()
+	m.Limit = d.uint32()
+}
+
+type Headers struct {
+	RequestID uint32
+	Height    uint64
+	Headers   []Header
+}
+
+func (m *Headers) Command() Command { return CmdHeaders }
+
+func (m *Headers) encode(e *encoder) {
+	e.uint32(m.RequestID)
+	e.uint64(m.Height)
+	e.uint32(uint32(len(m.Headers)))
+	for i := range m.Headers {
+		m.Headers[i].encode(e)
+	}
+}
+
+func (m *Headers) decode(d *decoder) {
+	m.RequestID = d.uint32()
+	m.Height = d.uint64()
+	m.Headers = make([]Header, d.count(92))
+	for i := range m.Headers {
+		m.Headers[i].decode(d)
+	}
+}
+
+type GetBlocks struct {
+	RequestID uint32
+	From      uint64
+	Count     uint32
+}
+
+func (m *GetBlocks) Command() Command { return CmdGetBlocks }
+
+func (m *GetBlocks) encode(e *encoder) {
+	e.uint32(m.RequestID)
+	e.uint64(m.From)
+	e.uint32(m.Count)
+}
+
+func (m *GetBlocks) decode(d *decoder) {
+	m.RequestID = d.uint32()
+	m.From = d.uint64()
+	m.Count = d.uint32()
+}
+
+// Transaction is a transfer as stored in a block. Pending transactions also
+// carry the sender's public key and signature; confirmed ones leave them
+// empty.
+type Transaction struct {
+	Sender    string
+	Receiver  string
+	Value     float64
+	Fee       float64
+	Nonce     uint64
+	PublicKey []byte
+	Signature []byte
+}
+
+func (t *Transaction) encode(e *encoder) {
+	e.string(t.Sender)
+	e.string(t.Receiver)
+	e.float64(t.Value)
+	e.float64(t.Fee)
+	e.uint64(t.Nonce)
+	e.bytes(t.PublicKey)
+	e.bytes(t.Signature)
+}
+
+func (t *Transaction) decode(d *decoder) {
+	t.Sender = d.string()
+	t.Receiver = d.string()
+	t.Value = d.float64()
+	t.Fee = d.float64()
+	t.Nonce = d.uint64()
+	t.PublicKey = d.bytes()
+	t.Signature = d.bytes()
+}
+
+type Block struct {
+	Height       uint64
+	Timestamp    int64
+	Nonce        int64
+	PreviousHash [32]byte
+	Transactions []Transaction
+	ExtraData    []byte
+}
+
+func (m *Block) Command() Command { return CmdBlock }
+
+func (m *Block) encode(e *encoder) {
+	e.uint64(m.Height)
+	e.int64(m.Timestamp)
+	e.int64(m.Nonce)
+	e.hash(m.PreviousHash)
+	e.uint32(uint32(len(m.Transactions)))
+	for i := range m.Transactions {
+		m.Transactions[i].encode(e)
+	}
+	e.bytes(m.ExtraData)
+}
+
+func (m *Block) decode(d *decoder) {
+	m.Height = d.uint64()
+	m.Timestamp = d.int64()
+	m.Nonce = d.int64()
+	m.PreviousHash = d.hash()
+	m.Transactions = make([]Transaction, d.count(40))
+	for i := range m.Transactions {
+		m.Transactions[i].decode(d)
+	}
+	m.ExtraData = d.bytes()
+}
+
+type Blocks struct {
+	RequestID uint32
+	Blocks    []Block
+}
+
+func (m *Blocks) Command() Command { return CmdBlocks }
+
+func (m *Blocks) encode(e *encoder) {
+	e.uint32(m.RequestID)
+	e.uint32(uint32(len(m.Blocks)))
+	for i := range m.Blocks {
+		m.Blocks[i].encode(e)
+	}
+}
+
+func (m *Blocks) decode(d *decoder) {
+	m.RequestID = d.uint32()
+	m.Blocks = make([]Block, d.count(64))
+	for i := range m.Blocks {
+		m.Blocks[i].decode(d)
+	}
+}
+
+type Tx struct {
+	Transaction
+}
+
+func (m *Tx) Command() Command  { return CmdTx }
+func (m *Tx) encode(e *encoder) { m.Transaction.encode(e) }
+func (m *Tx) decode(d *decoder) { m.Transaction.decode(d) }
@@ -0,0 +1,83 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A raw transaction is a signed transaction serialized for handing to a
+// node in one piece:
+//
+//	version (1 byte) | transaction
+//
+// where transaction is encoded as in the tx message. The public key is the
+// 32 byte X and Y coordinates and the signature the 32 byte R and S values.
+// Addresses are base58 and far shorter than RAW_TRANSACTION_MAX_ADDRESS_SIZE,
+// so no raw transaction is larger than RAW_TRANSACTION_MAX_SIZE.
+const (
+	RAW_TRANSACTION_VERSION          = 1
+	RAW_TRANSACTION_KEY_SIZE         = 64
+	RAW_TRANSACTION_SIG_SIZE         = 64
+	RAW_TRANSACTION_MAX_ADDRESS_SIZE = 64
+
+	RAW_TRANSACTION_MAX_SIZE = 1 + // version
+		2*(4+RAW_TRANSACTION_MAX_ADDRESS_SIZE) + // sender and receiver
+		8 + 8 + 8 + // value, fee and nonce
+		4 + RAW_TRANSACTION_KEY_SIZE + 4 + RAW_TRANSACTION_SIG_SIZE
+)
+
+var (
+	ErrRawTransactionVersion = errors.New("wire: unknown raw transaction version")
+	ErrRawTransactionKey     = errors.New("wire: raw transaction public key must be 64 bytes")
+	ErrRawTransactionSig     = errors.New("wire: raw transaction signature must be 64 bytes")
+	ErrRawTransactionAddress = errors.New("wire: raw transaction address is too long")
+)
+
+func EncodeRawTransaction(t *Transaction) []byte {
+	var buf bytes.Buffer
+	e := &encoder{&buf}
+	e.uint8(RAW_TRANSACTION_VERSION)
+	t.encode(e)
+	return buf.Bytes()
+}
+
+func DecodeRawTransaction(raw []byte) (*Transaction, error) {
+	d := &decoder{payload: raw}
+	if version := d.uint8(); d.err == nil && version != RAW_TRANSACTION_VERSION {
+		return nil, ErrRawTransactionVersion
+	}
+	t := &Transaction{}
+	t.decode(d)
+	if d.err != nil {
+		return nil, fmt.Errorf("wire: decoding raw transaction: %w", d.err)
+	}
+	if len(d.payload) != 0 {
+		return nil, fmt.Errorf("wire: %d trailing bytes after raw transaction", len(d.payload))
+	}
+	if len(t.PublicKey) != RAW_TRANSACTION_KEY_SIZE {
+		return nil, ErrRawTransactionKey
+	}
+	if len(t.Signature) != RAW_TRANSACTION_SIG_SIZE {
+		return nil, ErrRawTransactionSig
+	}
+	if len(t.Sender) > RAW_TRANSACTION_MAX_ADDRESS_SIZE || len(t.Receiver) > RAW_TRANSACTION_MAX_ADDRESS_SIZE {
+		return nil, ErrRawTransactionAddress
+	}
+	return t, nil
+}
+
+func EncodeRawTransactionHex(t *Transaction) string {
+	return hex.EncodeToString(EncodeRawTransaction(t))
+}
+
+// DecodeRawTransactionHex accepts the hex form with or without a 0x prefix.
+func DecodeRawTransactionHex(s string) (*Transaction, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("wire: raw transaction is not hex: %w", err)
+	}
+	return DecodeRawTransaction(raw)
+}
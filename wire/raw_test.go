@@ -0,0 +1,22 @@
+package wire
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestDecodeRawTransactionNonFinite(t *testing.T) {
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		for _, tx := range []*Transaction{
+			{Sender: "alice", Receiver: "bob", Value: v, Fee: 0.1},
+			{Sender: "alice", Receiver: "bob", Value: 1, Fee: v},
+		} {
+			tx.PublicKey = make([]byte, RAW_TRANSACTION_KEY_SIZE)
+			tx.Signature = make([]byte, RAW_TRANSACTION_SIG_SIZE)
+			if _, err := DecodeRawTransaction(EncodeRawTransaction(tx)); !errors.Is(err, ErrNonFiniteFloat) {
+				t.Errorf("value %v, fee %v: got %v, want %v", tx.Value, tx.Fee, err, ErrNonFiniteFloat)
+			}
+		}
+	}
+}